@@ -0,0 +1,314 @@
+// Package main implements a tool for inspecting and replaying dead-lettered orders.
+//
+// Usage:
+//
+//	dlq list   [-error text] [-since time] [-until time] [-order uid]
+//	dlq replay [-error text] [-since time] [-until time] [-order uid] [-mode topic|process] [-dry-run]
+//
+// The replay command either republishes matching messages to the main topic, where the running
+// service consumes them again, or processes them directly against the database.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"wildberries-tech/internal/cache"
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/kafka"
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/metrics"
+	"wildberries-tech/internal/repository"
+	"wildberries-tech/internal/tracing"
+)
+
+const (
+	modeTopic   = "topic"
+	modeProcess = "process"
+)
+
+// replayFunc replays a single DLQ message.
+type replayFunc func(ctx context.Context, m kafka.DLQMessage) error
+
+// errSkipped is returned by a replayFunc when the message did not need to be replayed.
+var errSkipped = errors.New("skipped")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	errorText := flags.String("error", "", "only messages whose error contains this text")
+	since := flags.String("since", "", "only messages dead-lettered at or after this RFC3339 time")
+	until := flags.String("until", "", "only messages dead-lettered before this RFC3339 time")
+	orderUID := flags.String("order", "", "only messages for this order UID")
+	mode := flags.String("mode", modeTopic, "replay mode: topic (republish) or process (save directly)")
+	dryRun := flags.Bool("dry-run", false, "show what would be replayed without replaying it")
+
+	switch command {
+	case "list", "replay":
+		if err := flags.Parse(os.Args[2:]); err != nil {
+			log.Fatalf("Invalid arguments: %v", err)
+		}
+	default:
+		usage()
+	}
+
+	filter := kafka.DLQFilter{
+		ErrorContains: *errorText,
+		Since:         parseTime("since", *since),
+		Until:         parseTime("until", *until),
+		OrderUID:      *orderUID,
+	}
+
+	if err := run(command, filter, *mode, *dryRun); err != nil {
+		log.Fatalf("DLQ %s failed: %v", command, err)
+	}
+}
+
+func run(command string, filter kafka.DLQFilter, mode string, dryRun bool) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Logs go to stderr so that they do not mix with the listing on stdout.
+	logger, err := logging.New(cfg.Logging, os.Stderr)
+	if err != nil {
+		return fmt.Errorf("failed to initialize logging: %w", err)
+	}
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Replayed messages continue the traces of the orders they carry.
+	tracer, err := tracing.New(ctx, tracing.Config{
+		ServiceName:    "order-dlq",
+		ServiceVersion: "1.0.0",
+		Environment:    "development",
+		JaegerEndpoint: cfg.Tracing.Endpoint,
+		Enabled:        cfg.Tracing.Enabled,
+	})
+	if err != nil {
+		logger.Warn("Failed to initialize tracing", logging.Err(err))
+	}
+	defer func() {
+		if err := tracer.Shutdown(context.Background()); err != nil {
+			logger.Warn("Error shutting down tracer", logging.Err(err))
+		}
+	}()
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Producer.Return.Successes = true
+
+	client, err := sarama.NewClient(cfg.Kafka.Brokers, saramaConfig)
+	if err != nil {
+		return fmt.Errorf("error creating Kafka client: %w", err)
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			logger.Warn("Error closing Kafka client", logging.Err(err))
+		}
+	}()
+
+	if command == "list" {
+		return list(ctx, client, cfg.Kafka.DLQTopic, filter)
+	}
+	return replay(ctx, logger, client, cfg, filter, mode, dryRun)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dlq list|replay [flags]")
+	fmt.Fprintln(os.Stderr, "run 'dlq list -h' or 'dlq replay -h' for the list of flags")
+	os.Exit(2)
+}
+
+func parseTime(name, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("Invalid -%s time %q: %v", name, value, err)
+	}
+	return t
+}
+
+func list(ctx context.Context, client sarama.Client, topic string, filter kafka.DLQFilter) error {
+	total, matched := 0, 0
+
+	err := kafka.ReadDLQ(ctx, client, topic, func(m kafka.DLQMessage) error {
+		total++
+		if !filter.Matches(m) {
+			return nil
+		}
+		matched++
+		printMessage(m)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d of %d messages matched\n", matched, total)
+	return nil
+}
+
+func replay(ctx context.Context, logger *slog.Logger, client sarama.Client, cfg *config.Config, filter kafka.DLQFilter,
+	mode string, dryRun bool) error {
+	replayOne, cleanup, err := newReplayer(logger, client, cfg, mode, dryRun)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var total, matched, replayed, skipped, failed int
+
+	err = kafka.ReadDLQ(ctx, client, cfg.Kafka.DLQTopic, func(m kafka.DLQMessage) error {
+		total++
+		if !filter.Matches(m) {
+			return nil
+		}
+		matched++
+		printMessage(m)
+
+		switch err := replayOne(ctx, m); {
+		case err == nil:
+			replayed++
+		case errors.Is(err, errSkipped):
+			skipped++
+		case ctx.Err() != nil:
+			return ctx.Err()
+		default:
+			failed++
+			logger.Warn("Replay failed again",
+				slog.Int(logging.KeyPartition, int(m.Partition)), slog.Int64(logging.KeyOffset, m.Offset), logging.Err(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d of %d messages matched: %d replayed, %d skipped, %d failed\n",
+		matched, total, replayed, skipped, failed)
+	return nil
+}
+
+// newReplayer returns the replay function for the given mode and a cleanup function
+// that releases the resources it holds.
+func newReplayer(logger *slog.Logger, client sarama.Client, cfg *config.Config, mode string, dryRun bool) (replayFunc, func(), error) {
+	if dryRun {
+		return func(context.Context, kafka.DLQMessage) error { return errSkipped }, func() {}, nil
+	}
+
+	switch mode {
+	case modeTopic:
+		producer, err := sarama.NewSyncProducerFromClient(client)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating producer: %w", err)
+		}
+		cleanup := func() {
+			if err := producer.Close(); err != nil {
+				logger.Warn("Error closing producer", logging.Err(err))
+			}
+		}
+		return republish(producer, cfg.Kafka.Topic, cfg.Kafka.DLQTopic), cleanup, nil
+
+	case modeProcess:
+		repo, err := repository.New(cfg.Database, logger)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error initializing repository: %w", err)
+		}
+		cleanup := func() {
+			if err := repo.Close(); err != nil {
+				logger.Warn("Error closing repository", logging.Err(err))
+			}
+		}
+		c, err := cache.NewFromConfig(cfg.Cache, metrics.Nop{}, logger)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if closer, ok := c.(io.Closer); ok {
+			closeRepo := cleanup
+			cleanup = func() {
+				if err := closer.Close(); err != nil {
+					logger.Warn("Error closing cache", logging.Err(err))
+				}
+				closeRepo()
+			}
+		}
+		consumer := kafka.NewConsumer(repo, c, metrics.Nop{}, cfg.Kafka, nil, logger)
+		return reprocess(consumer), cleanup, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown replay mode %q", mode)
+	}
+}
+
+// republish sends DLQ messages back to the main topic, keeping their original key and trace.
+func republish(producer sarama.SyncProducer, topic, dlqTopic string) replayFunc {
+	return func(ctx context.Context, m kafka.DLQMessage) error {
+		msg := &sarama.ProducerMessage{
+			Topic: topic,
+			Value: sarama.ByteEncoder(m.Value),
+			Headers: []sarama.RecordHeader{
+				{
+					Key:   []byte(kafka.HeaderReplayedFrom),
+					Value: []byte(fmt.Sprintf("%s/%d@%d", dlqTopic, m.Partition, m.Offset)),
+				},
+			},
+		}
+		if len(m.Key) > 0 {
+			msg.Key = sarama.ByteEncoder(m.Key)
+		}
+
+		ctx, span := kafka.StartPublishSpan(m.TraceContext(ctx), topic)
+		defer span.End()
+		kafka.InjectTraceContext(ctx, msg)
+
+		_, _, err := producer.SendMessage(msg)
+		if err != nil {
+			span.RecordError(err)
+		}
+		return err
+	}
+}
+
+// reprocess saves DLQ messages directly through the consumer's processing logic.
+// Orders that are already stored are reported as skipped.
+func reprocess(consumer *kafka.Consumer) replayFunc {
+	return func(ctx context.Context, m kafka.DLQMessage) error {
+		err := consumer.Reprocess(m.TraceContext(ctx), m.Value)
+		if errors.Is(err, repository.ErrDuplicateOrder) {
+			return errSkipped
+		}
+		return err
+	}
+}
+
+func printMessage(m kafka.DLQMessage) {
+	fmt.Printf("%d@%d\t%s\torder=%s\tsource=%s/%d@%d\tconsumer=%s\tattempts=%d\tcategory=%s",
+		m.Partition, m.Offset, m.FailedAt.Format(time.RFC3339), m.OrderUID,
+		m.SourceTopic, m.SourcePartition, m.SourceOffset, m.ConsumerID, m.Attempts, m.Category)
+	if len(m.ValidationFields) > 0 {
+		fmt.Printf("\tfields=%s", strings.Join(m.ValidationFields, ","))
+	}
+	fmt.Printf("\terror=%s\n", m.Error)
+}
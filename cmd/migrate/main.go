@@ -0,0 +1,136 @@
+// Package main implements a tool for managing the schema of the order database.
+//
+// Usage:
+//
+//	migrate up
+//	migrate down [-steps n]
+//	migrate status
+//	migrate force VERSION
+//
+// The force command marks VERSION as cleanly applied without running anything; it is used
+// after repairing a schema left dirty by an interrupted migration.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/migrate"
+	"wildberries-tech/internal/repository"
+	"wildberries-tech/migrations"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+
+	switch command {
+	case "up", "down", "status", "force":
+		if err := flags.Parse(os.Args[2:]); err != nil {
+			log.Fatalf("Invalid arguments: %v", err)
+		}
+	default:
+		usage()
+	}
+
+	if err := run(command, *steps, flags.Args()); err != nil {
+		log.Fatalf("Migrate %s failed: %v", command, err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up | down [-steps n] | status | force VERSION")
+	os.Exit(2)
+}
+
+func run(command string, steps int, args []string) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	logger, err := logging.New(cfg.Logging, os.Stderr)
+	if err != nil {
+		return fmt.Errorf("failed to initialize logging: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	repo, err := repository.New(cfg.Database, logger)
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+	defer func() {
+		if err := repo.Close(); err != nil {
+			logger.Warn("Error closing database", logging.Err(err))
+		}
+	}()
+
+	sqlDB, err := repo.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get sql db: %w", err)
+	}
+	migrator, err := migrate.New(sqlDB, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		fmt.Printf("%d migration(s) applied\n", applied)
+		if err != nil {
+			return err
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		fmt.Printf("%d migration(s) reverted\n", reverted)
+		if err != nil {
+			return err
+		}
+	case "force":
+		if len(args) != 1 {
+			usage()
+		}
+		version, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %w", args[0], err)
+		}
+		if err := migrator.Force(ctx, version); err != nil {
+			return err
+		}
+	}
+
+	return printStatus(ctx, migrator)
+}
+
+func printStatus(ctx context.Context, migrator *migrate.Migrator) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version: %d (latest %d)", status.Version, status.Latest)
+	if status.Dirty {
+		fmt.Print(", dirty")
+	}
+	fmt.Println()
+	for _, m := range status.Pending {
+		fmt.Printf("pending: %06d_%s\n", m.Version, m.Name)
+	}
+	return nil
+}
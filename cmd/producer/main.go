@@ -2,12 +2,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"log/slog"
+	"os"
 	"time"
 
 	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/kafka"
+	"wildberries-tech/internal/logging"
 	"wildberries-tech/internal/models"
+	"wildberries-tech/internal/tracing"
 
 	"github.com/IBM/sarama"
 	"github.com/brianvoe/gofakeit/v6"
@@ -19,16 +25,40 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	logger, err := logging.New(cfg.Logging, os.Stdout)
+	if err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Every order starts a trace that the service continues when it consumes the order.
+	tracer, err := tracing.New(context.Background(), tracing.Config{
+		ServiceName:    "order-producer",
+		ServiceVersion: "1.0.0",
+		Environment:    "development",
+		JaegerEndpoint: cfg.Tracing.Endpoint,
+		Enabled:        cfg.Tracing.Enabled,
+	})
+	if err != nil {
+		logger.Warn("Failed to initialize tracing", logging.Err(err))
+	}
+	defer func() {
+		if err := tracer.Shutdown(context.Background()); err != nil {
+			logger.Warn("Error shutting down tracer", logging.Err(err))
+		}
+	}()
+
 	saramaConfig := sarama.NewConfig()
 	saramaConfig.Producer.Return.Successes = true
 
 	producer, err := sarama.NewSyncProducer(cfg.Kafka.Brokers, saramaConfig)
 	if err != nil {
-		log.Fatalf("Error creating producer: %v", err)
+		logger.Error("Error creating producer", logging.Err(err))
+		os.Exit(1)
 	}
 	defer func() {
 		if err := producer.Close(); err != nil {
-			log.Println("Error closing producer:", err)
+			logger.Warn("Error closing producer", logging.Err(err))
 		}
 	}()
 
@@ -38,20 +68,29 @@ func main() {
 		order := generateOrder()
 		data, err := json.Marshal(order)
 		if err != nil {
-			log.Println("Error marshaling order:", err)
+			logger.Error("Error marshaling order", logging.OrderUID(order.OrderUID), logging.Err(err))
 			continue
 		}
 
 		message := &sarama.ProducerMessage{
 			Topic: cfg.Kafka.Topic,
+			Key:   sarama.StringEncoder(order.OrderUID),
 			Value: sarama.StringEncoder(data),
 		}
 
+		ctx, span := kafka.StartPublishSpan(context.Background(), cfg.Kafka.Topic)
+		kafka.InjectTraceContext(ctx, message)
 		partition, offset, err := producer.SendMessage(message)
 		if err != nil {
-			log.Println("Error sending message:", err)
+			span.RecordError(err)
+		}
+		span.End()
+		if err != nil {
+			logger.ErrorContext(ctx, "Error sending message", logging.OrderUID(order.OrderUID), logging.Err(err))
 		} else {
-			log.Printf("Sent order %s to partition %d at offset %d\n", order.OrderUID, partition, offset)
+			logger.InfoContext(ctx, "Sent order", logging.OrderUID(order.OrderUID),
+				slog.String(logging.KeyTopic, cfg.Kafka.Topic),
+				slog.Int(logging.KeyPartition, int(partition)), slog.Int64(logging.KeyOffset, offset))
 		}
 
 		time.Sleep(5 * time.Second)
@@ -3,8 +3,10 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,9 +22,12 @@ import (
 	"wildberries-tech/internal/handlers"
 	"wildberries-tech/internal/health"
 	"wildberries-tech/internal/kafka"
+	"wildberries-tech/internal/logging"
 	"wildberries-tech/internal/metrics"
+	"wildberries-tech/internal/migrate"
 	"wildberries-tech/internal/repository"
 	"wildberries-tech/internal/tracing"
+	"wildberries-tech/migrations"
 )
 
 func main() {
@@ -31,6 +36,12 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	logger, err := logging.New(cfg.Logging, os.Stdout)
+	if err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -43,76 +54,104 @@ func main() {
 		Enabled:        cfg.Tracing.Enabled,
 	})
 	if err != nil {
-		log.Printf("Warning: failed to initialize tracing: %v", err)
+		logger.Warn("Failed to initialize tracing", logging.Err(err))
 	}
 	defer func() {
-		if tracer != nil {
-			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer shutdownCancel()
-			if err := tracer.Shutdown(shutdownCtx); err != nil {
-				log.Printf("Error shutting down tracer: %v", err)
-			}
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := tracer.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("Error shutting down tracer", logging.Err(err))
 		}
 	}()
 
-	repo, err := repository.New(cfg.Database)
+	repo, err := repository.New(cfg.Database, logger)
 	if err != nil {
-		log.Printf("Failed to initialize repository: %v", err)
+		logger.Error("Failed to initialize repository", logging.Err(err))
 		return
 	}
 	defer func() {
 		if err := repo.Close(); err != nil {
-			log.Println("Error closing repository:", err)
+			logger.Warn("Error closing repository", logging.Err(err))
 		}
 	}()
 
-	c := cache.New(cfg.Cache.TTL, cfg.Cache.CleanupInterval)
+	if err := checkSchema(ctx, repo); err != nil {
+		logger.Error("Refusing to start", logging.Err(err))
+		return
+	}
+
+	m := metrics.NewPrometheus()
 
-	orders, err := repo.GetAllOrders()
+	c, err := cache.NewFromConfig(cfg.Cache, m, logger)
 	if err != nil {
-		log.Printf("Warning: failed to load orders from DB: %v", err)
-	} else {
-		c.LoadFromDB(orders)
-		log.Printf("Loaded %d orders to cache", len(orders))
+		logger.Error("Failed to initialize cache", logging.Err(err))
+		return
+	}
+	if closer, ok := c.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				logger.Warn("Error closing cache", logging.Err(err))
+			}
+		}()
 	}
 
-	handler := handlers.New(repo, c)
-
-	m := metrics.NewPrometheus()
+	handler := handlers.New(repo, c, m, cfg.Cache.NegativeTTL, logger)
 
 	// Initialize health checker
 	sqlDB, err := repo.DB()
 	if err != nil {
-		log.Printf("Warning: failed to get sql.DB for health checks: %v", err)
+		logger.Warn("Failed to get sql.DB for health checks", logging.Err(err))
+	}
+	healthChecker := health.NewChecker(m, cfg.Health, logger)
+	healthChecker.Register(health.NewDatabaseCheck(sqlDB), 0)
+	kafkaCheck := health.NewKafkaCheck(cfg.Kafka)
+	defer func() {
+		if err := kafkaCheck.Close(); err != nil {
+			logger.Warn("Error closing Kafka health check", logging.Err(err))
+		}
+	}()
+	healthChecker.Register(kafkaCheck, 0)
+	if sizer, ok := c.(health.CacheSizer); ok {
+		healthChecker.Register(health.NewCacheCheck(sizer), 0)
 	}
-	healthChecker := health.NewChecker(sqlDB, cfg.Kafka.Brokers, m, 30*time.Second)
-	go healthChecker.Start(ctx)
 
-	consumer := kafka.NewConsumer(repo, c, m, cfg.Kafka.Brokers, cfg.Kafka.Topic, cfg.Kafka.DLQTopic)
+	// Warm the cache up in the background; until it is done, cache misses are served from the database.
+	go func() {
+		if loaded, err := cache.WarmUp(ctx, repo, c, cfg.Cache, logger); err != nil {
+			logger.Warn("Cache warm-up stopped early", "loaded", loaded, logging.Err(err))
+		}
+		healthChecker.SetCacheWarm()
+	}()
+
+	consumer := kafka.NewConsumer(repo, c, m, cfg.Kafka, healthChecker, logger)
+	healthChecker.Register(health.NewConsumerLagCheck(consumer, cfg.Health.MaxConsumerLag), 0)
+	healthChecker.Register(health.NewDLQProducerCheck(consumer), 0)
+	go healthChecker.Start(ctx)
 
+	consumerDone := make(chan struct{})
 	go func() {
+		defer close(consumerDone)
 		if err := consumer.Start(ctx); err != nil {
-			log.Printf("Consumer stopped with error: %v", err)
+			logger.Error("Consumer stopped with error", logging.Err(err))
 			cancel()
 		}
 	}()
 
 	r := mux.NewRouter()
 	r.Use(otelmux.Middleware("order-service"))
+	r.Use(handlers.RequestIDMiddleware)
+	r.Use(handlers.MetricsMiddleware(m))
 	r.Handle("/metrics", promhttp.Handler())
-	r.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
-		status := healthChecker.Status()
-		w.Header().Set("Content-Type", "application/json")
-		if healthChecker.IsHealthy() {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			w.WriteHeader(http.StatusServiceUnavailable)
-		}
-		if err := json.NewEncoder(w).Encode(status); err != nil {
-			log.Printf("Error encoding health status: %v", err)
-		}
-	}).Methods("GET")
+	r.HandleFunc("/livez", healthChecker.Livez).Methods("GET")
+	r.HandleFunc("/startupz", healthChecker.Startupz).Methods("GET")
+	r.HandleFunc("/readyz", healthChecker.Readyz).Methods("GET")
+	r.HandleFunc("/health", healthChecker.Readyz).Methods("GET")
 	r.HandleFunc("/order/{order_uid}", handler.GetOrder).Methods("GET")
+	r.HandleFunc("/orders", handler.ListOrders).Methods("GET")
+	r.HandleFunc("/orders/by-track/{track_number}", handler.FindByTrackNumber).Methods("GET")
+	r.HandleFunc("/orders/by-transaction/{transaction}", handler.FindByTransaction).Methods("GET")
+	r.HandleFunc("/orders/by-rid/{rid}", handler.FindByRID).Methods("GET")
+	r.HandleFunc("/customers/{customer_id}/orders", handler.ListCustomerOrders).Methods("GET")
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./web/")))
 
 	srv := &http.Server{
@@ -121,24 +160,51 @@ func main() {
 	}
 
 	go func() {
-		log.Printf("Server starting on %s", srv.Addr)
+		logger.Info("Server starting", "addr", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe(): %v", err)
+			logger.Error("Server failed", logging.Err(err))
+			os.Exit(1)
 		}
 	}()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	logger.Info("Shutting down server")
 	cancel()
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer shutdownCancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		logger.Warn("Server forced to shutdown", logging.Err(err))
+	}
+
+	// The consumer commits offsets and writes to the repository and the cache until it returns,
+	// so it has to stop before they are closed by the deferred calls.
+	select {
+	case <-consumerDone:
+	case <-shutdownCtx.Done():
+		logger.Warn("Consumer did not stop before the shutdown timeout")
 	}
 
-	log.Println("Server exiting")
+	logger.Info("Server exiting")
+}
+
+// checkSchema verifies that every migration has been applied to the database.
+// The schema is managed by cmd/migrate and is never changed by the server itself.
+func checkSchema(ctx context.Context, repo *repository.Repository) error {
+	sqlDB, err := repo.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get sql db: %w", err)
+	}
+
+	migrator, err := migrate.New(sqlDB, migrations.FS)
+	if err != nil {
+		return err
+	}
+	if err := migrator.Check(ctx); err != nil {
+		return fmt.Errorf("%w (run 'make migrate-up' to apply pending migrations)", err)
+	}
+	return nil
 }
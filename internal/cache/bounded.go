@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"wildberries-tech/internal/metrics"
+	"wildberries-tech/internal/models"
+)
+
+// Eviction policies of the bounded cache.
+const (
+	PolicyLRU = "lru"
+	PolicyLFU = "lfu"
+)
+
+// Stats describes the contents of a bounded cache.
+type Stats struct {
+	Entries int
+	// Bytes is the approximate memory held by the cached orders.
+	Bytes     int64
+	Evictions uint64
+}
+
+// Bounded is an OrderCache limited to a maximum number of entries and an approximate byte budget.
+// When a limit is exceeded, entries are evicted in least recently used (PolicyLRU) or least
+// frequently used (PolicyLFU) order. Entries also expire after a TTL; expired entries are
+// dropped when they are read or chosen for eviction.
+type Bounded struct {
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+	metrics    metrics.Metrics
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	policy    evictionPolicy
+	bytes     int64
+	evictions uint64
+}
+
+type entry struct {
+	key       string
+	order     models.Order
+	size      int64
+	expiresAt time.Time
+
+	// Bookkeeping of the eviction policy.
+	element  *list.Element
+	uses     uint64
+	lastUsed uint64
+	index    int
+}
+
+// evictionPolicy orders the entries of a Bounded cache for eviction.
+type evictionPolicy interface {
+	add(e *entry)
+	touch(e *entry)
+	remove(e *entry)
+	// victim returns the entry to evict next.
+	victim() *entry
+}
+
+// NewBounded creates a Bounded cache reporting to m. A zero maxEntries or maxBytes disables
+// that limit, and a zero ttl keeps entries until they are evicted.
+func NewBounded(policy string, maxEntries int, maxBytes int64, ttl time.Duration,
+	m metrics.Metrics) (*Bounded, error) {
+	c := &Bounded{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		metrics:    m,
+		entries:    make(map[string]*entry),
+	}
+
+	switch policy {
+	case PolicyLRU:
+		c.policy = &lruPolicy{order: list.New()}
+	case PolicyLFU:
+		c.policy = &lfuPolicy{}
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", policy)
+	}
+	return c, nil
+}
+
+// Set adds an order to the cache, evicting other orders if the cache is full.
+func (c *Bounded) Set(_ context.Context, orderUID string, order models.Order) {
+	size := orderSize(order)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[orderUID]
+	if ok {
+		c.bytes += size - e.size
+		e.order, e.size, e.expiresAt = order, size, c.expiry()
+		c.policy.touch(e)
+	} else {
+		// Make room before adding the order, so that a new order is never its own victim.
+		c.evictLocked(func() bool { return c.overLimit(1, size) })
+		e = &entry{key: orderUID, order: order, size: size, expiresAt: c.expiry()}
+		c.entries[orderUID] = e
+		c.bytes += size
+		c.policy.add(e)
+	}
+
+	// An update may have grown the order beyond the byte budget.
+	c.evictLocked(func() bool { return c.overLimit(0, 0) && c.policy.victim() != e })
+
+	c.metrics.IncCacheSets()
+	c.metrics.SetCacheSize(len(c.entries))
+}
+
+// evictLocked evicts entries while over returns true and the cache is not empty.
+// A single order larger than the byte budget is still cached.
+func (c *Bounded) evictLocked(over func() bool) {
+	now := time.Now()
+	for len(c.entries) > 0 && over() {
+		victim := c.policy.victim()
+		if c.expired(victim, now) {
+			c.metrics.IncCacheExpirations()
+		} else {
+			c.evictions++
+			c.metrics.IncCacheEvictions()
+		}
+		c.removeLocked(victim)
+	}
+}
+
+// Get retrieves an order from the cache.
+func (c *Bounded) Get(_ context.Context, orderUID string) (models.Order, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[orderUID]
+	if !ok {
+		return models.Order{}, false
+	}
+	if c.expired(e, time.Now()) {
+		c.removeLocked(e)
+		c.metrics.IncCacheExpirations()
+		c.metrics.SetCacheSize(len(c.entries))
+		return models.Order{}, false
+	}
+
+	c.policy.touch(e)
+	return e.order, true
+}
+
+// LoadFromDB populates the cache with a list of orders.
+func (c *Bounded) LoadFromDB(ctx context.Context, orders []models.Order) {
+	for _, order := range orders {
+		c.Set(ctx, order.OrderUID, order)
+	}
+}
+
+// Stats returns the current size of the cache and the number of entries evicted so far.
+// Entries dropped because they expired are not counted as evictions.
+func (c *Bounded) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Entries: len(c.entries), Bytes: c.bytes, Evictions: c.evictions}
+}
+
+// Len returns the number of cached orders.
+func (c *Bounded) Len(context.Context) (int, error) {
+	return c.Stats().Entries, nil
+}
+
+// overLimit reports whether the cache exceeds its limits once extraEntries entries
+// of extraBytes bytes in total are added.
+func (c *Bounded) overLimit(extraEntries int, extraBytes int64) bool {
+	return (c.maxEntries > 0 && len(c.entries)+extraEntries > c.maxEntries) ||
+		(c.maxBytes > 0 && c.bytes+extraBytes > c.maxBytes)
+}
+
+func (c *Bounded) expiry() time.Time {
+	if c.ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.ttl)
+}
+
+func (c *Bounded) expired(e *entry, now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+func (c *Bounded) removeLocked(e *entry) {
+	c.policy.remove(e)
+	delete(c.entries, e.key)
+	c.bytes -= e.size
+}
+
+// lruPolicy evicts the least recently used entry. The front of the list is the most recently used.
+type lruPolicy struct {
+	order *list.List
+}
+
+func (p *lruPolicy) add(e *entry)    { e.element = p.order.PushFront(e) }
+func (p *lruPolicy) touch(e *entry)  { p.order.MoveToFront(e.element) }
+func (p *lruPolicy) remove(e *entry) { p.order.Remove(e.element) }
+func (p *lruPolicy) victim() *entry  { return p.order.Back().Value.(*entry) }
+
+// lfuPolicy evicts the least frequently used entry, and the least recently used among equally used ones.
+type lfuPolicy struct {
+	entries lfuHeap
+	clock   uint64
+}
+
+func (p *lfuPolicy) add(e *entry) {
+	p.clock++
+	e.uses, e.lastUsed = 1, p.clock
+	heap.Push(&p.entries, e)
+}
+
+func (p *lfuPolicy) touch(e *entry) {
+	p.clock++
+	e.uses++
+	e.lastUsed = p.clock
+	heap.Fix(&p.entries, e.index)
+}
+
+func (p *lfuPolicy) remove(e *entry) { heap.Remove(&p.entries, e.index) }
+func (p *lfuPolicy) victim() *entry  { return p.entries[0] }
+
+// lfuHeap is a min-heap of entries by use count and then by last use.
+type lfuHeap []*entry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].uses != h[j].uses {
+		return h[i].uses < h[j].uses
+	}
+	return h[i].lastUsed < h[j].lastUsed
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// orderSize approximates the memory held by an order: the size of its struct plus
+// the contents of its strings and items.
+func orderSize(order models.Order) int64 {
+	return int64(reflect.TypeOf(order).Size()) + indirectSize(reflect.ValueOf(order))
+}
+
+// indirectSize returns the memory referenced by v outside of v itself.
+func indirectSize(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		size := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSize(v.Index(i))
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += indirectSize(v.Field(i))
+		}
+		return size
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/metrics"
+	"wildberries-tech/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cached(c OrderCache, uids ...string) []string {
+	var found []string
+	for _, uid := range uids {
+		if _, ok := c.Get(context.Background(), uid); ok {
+			found = append(found, uid)
+		}
+	}
+	return found
+}
+
+func TestBounded_LRU(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewBounded(PolicyLRU, 3, 0, 0, metrics.Nop{})
+	require.NoError(t, err)
+
+	for _, uid := range []string{"a", "b", "c"} {
+		c.Set(ctx, uid, models.Order{OrderUID: uid})
+	}
+	c.Get(ctx, "a")
+	c.Set(ctx, "d", models.Order{OrderUID: "d"})
+
+	assert.Equal(t, []string{"a", "c", "d"}, cached(c, "a", "b", "c", "d"), "b was used least recently")
+	assert.Equal(t, Stats{Entries: 3, Bytes: c.Stats().Bytes, Evictions: 1}, c.Stats())
+}
+
+func TestBounded_LFU(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewBounded(PolicyLFU, 3, 0, 0, metrics.Nop{})
+	require.NoError(t, err)
+
+	for _, uid := range []string{"a", "b", "c"} {
+		c.Set(ctx, uid, models.Order{OrderUID: uid})
+	}
+	c.Get(ctx, "a")
+	c.Get(ctx, "a")
+	c.Get(ctx, "b")
+	c.Get(ctx, "c")
+	c.Set(ctx, "d", models.Order{OrderUID: "d"})
+
+	assert.Equal(t, []string{"a", "c", "d"}, cached(c, "a", "b", "c", "d"),
+		"b and c were used as often, b less recently")
+	assert.Equal(t, uint64(1), c.Stats().Evictions)
+}
+
+func TestBounded_ByteBudget(t *testing.T) {
+	ctx := context.Background()
+	order := models.Order{OrderUID: "a", Items: []models.Item{{Name: strings.Repeat("x", 1000)}}}
+	size := orderSize(order)
+	assert.Greater(t, size, int64(1000))
+
+	c, err := NewBounded(PolicyLRU, 0, 2*size+size/2, 0, metrics.Nop{})
+	require.NoError(t, err)
+
+	for _, uid := range []string{"a", "b", "c"} {
+		order.OrderUID = uid
+		c.Set(ctx, uid, order)
+	}
+
+	assert.Equal(t, []string{"b", "c"}, cached(c, "a", "b", "c"))
+	assert.Equal(t, 2*size, c.Stats().Bytes)
+
+	large := models.Order{OrderUID: "large", Items: []models.Item{{Name: strings.Repeat("x", 10000)}}}
+	c.Set(ctx, "large", large)
+	assert.Equal(t, []string{"large"}, cached(c, "b", "c", "large"), "an order above the budget is still cached alone")
+}
+
+func TestBounded_TTL(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewBounded(PolicyLRU, 10, 0, 50*time.Millisecond, metrics.Nop{})
+	require.NoError(t, err)
+
+	c.Set(ctx, "a", models.Order{OrderUID: "a"})
+	assert.Equal(t, []string{"a"}, cached(c, "a"))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, cached(c, "a"))
+	assert.Equal(t, Stats{}, c.Stats(), "expired entries are dropped, not evicted")
+}
+
+func TestBounded_Update(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewBounded(PolicyLFU, 2, 0, 0, metrics.Nop{})
+	require.NoError(t, err)
+
+	c.Set(ctx, "a", models.Order{OrderUID: "a", TrackNumber: "v1"})
+	c.Set(ctx, "a", models.Order{OrderUID: "a", TrackNumber: "v2"})
+
+	order, found := c.Get(ctx, "a")
+	require.True(t, found)
+	assert.Equal(t, "v2", order.TrackNumber)
+	assert.Equal(t, 1, c.Stats().Entries)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	c, err := NewFromConfig(config.CacheConfig{Backend: BackendMemory, Policy: PolicyTTL, TTL: time.Minute}, metrics.Nop{}, logging.Nop())
+	require.NoError(t, err)
+	assert.IsType(t, &Cache{}, c)
+
+	c, err = NewFromConfig(config.CacheConfig{Backend: BackendMemory, Policy: PolicyLFU, MaxEntries: 10}, metrics.Nop{}, logging.Nop())
+	require.NoError(t, err)
+	assert.IsType(t, &Bounded{}, c)
+
+	_, err = NewFromConfig(config.CacheConfig{Backend: BackendMemory, Policy: "fifo"}, metrics.Nop{}, logging.Nop())
+	assert.Error(t, err)
+}
+
+// countingMetrics counts the cache metrics it receives.
+type countingMetrics struct {
+	metrics.Nop
+	mu                                 sync.Mutex
+	sets, evictions, expirations, size int
+}
+
+func (m *countingMetrics) IncCacheSets()        { m.mu.Lock(); m.sets++; m.mu.Unlock() }
+func (m *countingMetrics) IncCacheEvictions()   { m.mu.Lock(); m.evictions++; m.mu.Unlock() }
+func (m *countingMetrics) IncCacheExpirations() { m.mu.Lock(); m.expirations++; m.mu.Unlock() }
+func (m *countingMetrics) SetCacheSize(n int)   { m.mu.Lock(); m.size = n; m.mu.Unlock() }
+
+func TestBounded_Metrics(t *testing.T) {
+	ctx := context.Background()
+	m := &countingMetrics{}
+	c, err := NewBounded(PolicyLRU, 2, 0, 50*time.Millisecond, m)
+	require.NoError(t, err)
+
+	for _, uid := range []string{"a", "b", "c"} {
+		c.Set(ctx, uid, models.Order{OrderUID: uid})
+	}
+	assert.Equal(t, 3, m.sets)
+	assert.Equal(t, 1, m.evictions)
+	assert.Equal(t, 2, m.size)
+
+	time.Sleep(100 * time.Millisecond)
+	c.Get(ctx, "b")
+	assert.Equal(t, 1, m.expirations)
+	assert.Equal(t, 1, m.size)
+}
+
+func TestCache_Metrics(t *testing.T) {
+	m := &countingMetrics{}
+	c := New(50*time.Millisecond, 20*time.Millisecond, m)
+
+	c.Set(context.Background(), "a", models.Order{OrderUID: "a"})
+	assert.Equal(t, 1, m.sets)
+
+	assert.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.expirations == 1 && m.size == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -1,39 +1,133 @@
-// Package cache implements in-memory caching for orders.
+// Package cache implements caching for orders, in memory and in Redis.
 package cache
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"time"
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/metrics"
 	"wildberries-tech/internal/models"
 
 	gocache "github.com/patrickmn/go-cache"
+	"github.com/redis/go-redis/v9"
 )
 
-// OrderCache defines the interface for caching orders
+// OrderCache defines the interface for caching orders. The context bounds
+// implementations backed by a remote store; the in-memory caches ignore it.
 type OrderCache interface {
-	Set(orderUID string, order models.Order)
-	Get(orderUID string) (models.Order, bool)
-	LoadFromDB(orders []models.Order)
+	Set(ctx context.Context, orderUID string, order models.Order)
+	Get(ctx context.Context, orderUID string) (models.Order, bool)
+	LoadFromDB(ctx context.Context, orders []models.Order)
+}
+
+// Sizer is implemented by caches that can report how many orders they hold.
+type Sizer interface {
+	Len(ctx context.Context) (int, error)
 }
 
 // Cache provides methods for storing and retrieving orders from memory.
 type Cache struct {
-	store *gocache.Cache
+	store   *gocache.Cache
+	metrics metrics.Metrics
+}
+
+// New initializes and returns a new Cache instance. Expired orders are removed,
+// and reported to m as expirations, every cleanupInterval.
+func New(defaultExpiration, cleanupInterval time.Duration, m metrics.Metrics) *Cache {
+	c := &Cache{
+		store:   gocache.New(defaultExpiration, cleanupInterval),
+		metrics: m,
+	}
+	c.store.OnEvicted(func(string, any) {
+		m.IncCacheExpirations()
+		m.SetCacheSize(c.store.ItemCount())
+	})
+	return c
 }
 
-// New initializes and returns a new Cache instance.
-func New(defaultExpiration, cleanupInterval time.Duration) *Cache {
-	return &Cache{
-		store: gocache.New(defaultExpiration, cleanupInterval),
+// PolicyTTL selects the unbounded Cache, which only drops entries once they expire.
+const PolicyTTL = "ttl"
+
+// Cache backends.
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+	BackendTiered = "tiered"
+)
+
+// NewFromConfig creates the OrderCache selected by cfg.Backend. The local cache is selected
+// by cfg.Policy. Caches backed by Redis fail to be created if Redis cannot be reached, and
+// implement io.Closer to release their connections.
+func NewFromConfig(cfg config.CacheConfig, m metrics.Metrics, logger *slog.Logger) (OrderCache, error) {
+	switch cfg.Backend {
+	case BackendMemory:
+		return newLocal(cfg, m)
+	case BackendRedis:
+		return newRedisFromConfig(cfg, m, logger)
+	case BackendTiered:
+		// Other replicas do not invalidate this L1, so it keeps orders only briefly.
+		localCfg := cfg
+		if cfg.LocalTTL > 0 && (cfg.TTL <= 0 || cfg.LocalTTL < cfg.TTL) {
+			localCfg.TTL = cfg.LocalTTL
+		}
+		local, err := newLocal(localCfg, m)
+		if err != nil {
+			return nil, err
+		}
+		// Orders written to the local cache are already reported to m.
+		shared, err := newRedisFromConfig(cfg, metrics.Nop{}, logger)
+		if err != nil {
+			return nil, err
+		}
+		return NewTiered(local, shared), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
 	}
 }
 
+func newLocal(cfg config.CacheConfig, m metrics.Metrics) (OrderCache, error) {
+	switch cfg.Policy {
+	case PolicyTTL:
+		return New(cfg.TTL, cfg.CleanupInterval, m), nil
+	default:
+		return NewBounded(cfg.Policy, cfg.MaxEntries, cfg.MaxBytes, cfg.TTL, m)
+	}
+}
+
+func newRedisFromConfig(cfg config.CacheConfig, m metrics.Metrics, logger *slog.Logger) (*Redis, error) {
+	codec, err := NewCodec(cfg.Codec)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.RedisAddr,
+		Password:     cfg.RedisPassword,
+		DB:           cfg.RedisDB,
+		DialTimeout:  cfg.RedisTimeout,
+		ReadTimeout:  cfg.RedisTimeout,
+		WriteTimeout: cfg.RedisTimeout,
+		// A failed command falls back to the database instead of being retried.
+		MaxRetries: -1,
+	})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis at %s: %w", cfg.RedisAddr, err)
+	}
+	return NewRedis(client, cfg.RedisKeyPrefix, cfg.TTL, codec, m, logger), nil
+}
+
 // Set adds an order to the cache.
-func (c *Cache) Set(orderUID string, order models.Order) {
+func (c *Cache) Set(_ context.Context, orderUID string, order models.Order) {
 	c.store.Set(orderUID, order, gocache.DefaultExpiration)
+	c.metrics.IncCacheSets()
+	c.metrics.SetCacheSize(c.store.ItemCount())
 }
 
 // Get retrieves an order from the cache.
-func (c *Cache) Get(orderUID string) (models.Order, bool) {
+func (c *Cache) Get(_ context.Context, orderUID string) (models.Order, bool) {
 	if val, found := c.store.Get(orderUID); found {
 		if order, ok := val.(models.Order); ok {
 			return order, true
@@ -43,8 +137,13 @@ func (c *Cache) Get(orderUID string) (models.Order, bool) {
 }
 
 // LoadFromDB populates the cache with a list of orders.
-func (c *Cache) LoadFromDB(orders []models.Order) {
+func (c *Cache) LoadFromDB(ctx context.Context, orders []models.Order) {
 	for _, order := range orders {
-		c.Set(order.OrderUID, order)
+		c.Set(ctx, order.OrderUID, order)
 	}
 }
+
+// Len returns the number of cached orders, including expired ones not yet cleaned up.
+func (c *Cache) Len(context.Context) (int, error) {
+	return c.store.ItemCount(), nil
+}
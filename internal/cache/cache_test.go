@@ -1,36 +1,38 @@
 package cache
 
 import (
+	"context"
 	"testing"
 	"time"
+	"wildberries-tech/internal/metrics"
 	"wildberries-tech/internal/models"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestCache(t *testing.T) {
-	c := New(5*time.Minute, 10*time.Minute)
+	c := New(5*time.Minute, 10*time.Minute, metrics.Nop{})
 
 	order := models.Order{OrderUID: "test-uid"}
-	c.Set("test-uid", order)
+	c.Set(context.Background(), "test-uid", order)
 
-	val, found := c.Get("test-uid")
+	val, found := c.Get(context.Background(), "test-uid")
 	assert.True(t, found)
 	assert.Equal(t, order, val)
 
-	val, found = c.Get("non-existent")
+	val, found = c.Get(context.Background(), "non-existent")
 	assert.False(t, found)
 	assert.Equal(t, models.Order{}, val)
 }
 
 func TestCacheTTL(t *testing.T) {
-	c := New(100*time.Millisecond, 200*time.Millisecond)
+	c := New(100*time.Millisecond, 200*time.Millisecond, metrics.Nop{})
 
 	order := models.Order{OrderUID: "test-uid"}
-	c.Set("test-uid", order)
+	c.Set(context.Background(), "test-uid", order)
 
 	time.Sleep(200 * time.Millisecond)
 
-	_, found := c.Get("test-uid")
+	_, found := c.Get(context.Background(), "test-uid")
 	assert.False(t, found, "Item should have expired")
 }
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"wildberries-tech/internal/models"
+)
+
+// Serialization codecs for orders stored outside the process.
+const (
+	CodecJSON = "json"
+	CodecGob  = "gob"
+)
+
+// Codec serializes orders for a remote cache.
+type Codec interface {
+	Marshal(order models.Order) ([]byte, error)
+	Unmarshal(data []byte, order *models.Order) error
+}
+
+// NewCodec returns the codec with the given name.
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case CodecJSON:
+		return jsonCodec{}, nil
+	case CodecGob:
+		return gobCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown cache codec %q", name)
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(order models.Order) ([]byte, error) {
+	return json.Marshal(order)
+}
+
+func (jsonCodec) Unmarshal(data []byte, order *models.Order) error {
+	return json.Unmarshal(data, order)
+}
+
+// gobCodec is more compact than JSON and also keeps fields that are not exported to JSON.
+type gobCodec struct{}
+
+func (gobCodec) Marshal(order models.Order) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(order); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, order *models.Order) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(order)
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/metrics"
+	"wildberries-tech/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// scanBatchSize is the number of keys Len asks Redis to look at per SCAN call.
+const scanBatchSize = 1000
+
+// globEscaper escapes the characters of a key prefix that SCAN MATCH treats as a pattern.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// Redis is an OrderCache shared by all replicas of the service. Orders are stored under
+// a key prefix with a TTL, and expire in Redis itself. Redis errors are logged and treated
+// as cache misses, so an unavailable Redis only costs database queries.
+type Redis struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	codec  Codec
+
+	metrics metrics.Metrics
+	logger  *slog.Logger
+}
+
+// NewRedis creates a Redis cache storing orders in client under prefix. A zero ttl keeps
+// orders until Redis evicts them.
+func NewRedis(client *redis.Client, prefix string, ttl time.Duration, codec Codec, m metrics.Metrics,
+	logger *slog.Logger) *Redis {
+	return &Redis{
+		client:  client,
+		prefix:  prefix,
+		ttl:     ttl,
+		codec:   codec,
+		metrics: m,
+		logger:  logger,
+	}
+}
+
+// Set stores an order in Redis.
+func (c *Redis) Set(ctx context.Context, orderUID string, order models.Order) {
+	data, err := c.codec.Marshal(order)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "Failed to encode order for cache", logging.OrderUID(orderUID), logging.Err(err))
+		return
+	}
+	if err := c.client.Set(ctx, c.key(orderUID), data, c.ttl).Err(); err != nil {
+		c.logger.WarnContext(ctx, "Failed to cache order in Redis", logging.OrderUID(orderUID), logging.Err(err))
+		return
+	}
+	c.metrics.IncCacheSets()
+}
+
+// Get retrieves an order from Redis.
+func (c *Redis) Get(ctx context.Context, orderUID string) (models.Order, bool) {
+	data, err := c.client.Get(ctx, c.key(orderUID)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			c.logger.WarnContext(ctx, "Failed to read order from Redis", logging.OrderUID(orderUID), logging.Err(err))
+		}
+		return models.Order{}, false
+	}
+
+	var order models.Order
+	if err := c.codec.Unmarshal(data, &order); err != nil {
+		c.logger.ErrorContext(ctx, "Failed to decode cached order", logging.OrderUID(orderUID), logging.Err(err))
+		return models.Order{}, false
+	}
+	return order, true
+}
+
+// LoadFromDB stores a list of orders in Redis in a single round trip.
+func (c *Redis) LoadFromDB(ctx context.Context, orders []models.Order) {
+	if len(orders) == 0 {
+		return
+	}
+	pipe := c.client.Pipeline()
+	for _, order := range orders {
+		data, err := c.codec.Marshal(order)
+		if err != nil {
+			c.logger.ErrorContext(ctx, "Failed to encode order for cache",
+				logging.OrderUID(order.OrderUID), logging.Err(err))
+			continue
+		}
+		pipe.Set(ctx, c.key(order.OrderUID), data, c.ttl)
+	}
+
+	cmds, err := pipe.Exec(ctx)
+	if err != nil {
+		c.logger.WarnContext(ctx, "Failed to cache orders in Redis", "orders", len(orders), logging.Err(err))
+	}
+	for _, cmd := range cmds {
+		if cmd.Err() == nil {
+			c.metrics.IncCacheSets()
+		}
+	}
+}
+
+// Len returns the number of keys under the prefix, which are the cached orders. It scans the
+// keyspace rather than asking for its size, since the database may hold other data. Unlike the
+// other methods it reports a failing Redis. The count is also reported as the cache size metric,
+// which therefore follows the periodic health checks of the cache.
+func (c *Redis) Len(ctx context.Context) (int, error) {
+	n := 0
+	iter := c.client.Scan(ctx, 0, globEscaper.Replace(c.prefix)+"*", scanBatchSize).Iterator()
+	for iter.Next(ctx) {
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
+	c.metrics.SetCacheSize(n)
+	return n, nil
+}
+
+// Close closes the Redis client.
+func (c *Redis) Close() error {
+	return c.client.Close()
+}
+
+func (c *Redis) key(orderUID string) string {
+	return c.prefix + orderUID
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/metrics"
+	"wildberries-tech/internal/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedis(t *testing.T, codec string) (*Redis, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	c, err := NewCodec(codec)
+	require.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return NewRedis(client, "order:", time.Minute, c, metrics.Nop{}, logging.Nop()), mr
+}
+
+func testOrder(uid string) models.Order {
+	return models.Order{
+		OrderUID:    uid,
+		TrackNumber: "TRACK123",
+		DateCreated: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Items:       []models.Item{{ChrtID: 1, TrackNumber: "TRACK123", Name: "item"}},
+	}
+}
+
+func TestRedis_Codecs(t *testing.T) {
+	for _, codec := range []string{CodecJSON, CodecGob} {
+		t.Run(codec, func(t *testing.T) {
+			c, mr := newTestRedis(t, codec)
+			ctx := context.Background()
+
+			order := testOrder("test-uid")
+			c.Set(ctx, "test-uid", order)
+			assert.True(t, mr.Exists("order:test-uid"))
+
+			val, found := c.Get(ctx, "test-uid")
+			require.True(t, found)
+			assert.Equal(t, order.OrderUID, val.OrderUID)
+			assert.True(t, order.DateCreated.Equal(val.DateCreated))
+			assert.Equal(t, order.Items[0].Name, val.Items[0].Name)
+
+			_, found = c.Get(ctx, "non-existent")
+			assert.False(t, found)
+		})
+	}
+
+	_, err := NewCodec("xml")
+	assert.Error(t, err)
+}
+
+func TestRedis_TTL(t *testing.T) {
+	c, mr := newTestRedis(t, CodecJSON)
+	ctx := context.Background()
+
+	c.Set(ctx, "test-uid", testOrder("test-uid"))
+	assert.Equal(t, time.Minute, mr.TTL("order:test-uid"))
+
+	mr.FastForward(2 * time.Minute)
+	_, found := c.Get(ctx, "test-uid")
+	assert.False(t, found, "Item should have expired")
+}
+
+func TestRedis_LoadFromDB(t *testing.T) {
+	c, mr := newTestRedis(t, CodecGob)
+	ctx := context.Background()
+
+	c.LoadFromDB(ctx, []models.Order{testOrder("a"), testOrder("b")})
+	assert.ElementsMatch(t, []string{"order:a", "order:b"}, mr.Keys())
+
+	_, found := c.Get(ctx, "b")
+	assert.True(t, found)
+}
+
+func TestRedis_Len(t *testing.T) {
+	c, mr := newTestRedis(t, CodecJSON)
+	m := &countingMetrics{}
+	c.metrics = m
+	ctx := context.Background()
+
+	c.LoadFromDB(ctx, []models.Order{testOrder("a"), testOrder("b")})
+	require.NoError(t, mr.Set("session:1", "not an order"))
+	n, err := c.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "keys outside the prefix are not counted")
+	assert.Equal(t, 2, m.size, "the count is reported as the cache size")
+
+	globbing := NewRedis(c.client, "o*:", time.Minute, c.codec, metrics.Nop{}, logging.Nop())
+	globbing.Set(ctx, "c", testOrder("c"))
+	n, err = globbing.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "the prefix is matched literally")
+
+	tiered := NewTiered(New(time.Minute, time.Minute, metrics.Nop{}), c)
+	n, err = tiered.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "a tiered cache reports the size of the shared cache")
+
+	mr.Close()
+	_, err = c.Len(ctx)
+	assert.Error(t, err, "unlike lookups, Len reports an unreachable Redis")
+}
+
+func TestRedis_Unavailable(t *testing.T) {
+	c, mr := newTestRedis(t, CodecJSON)
+	ctx := context.Background()
+
+	c.Set(ctx, "test-uid", testOrder("test-uid"))
+	mr.Close()
+
+	_, found := c.Get(ctx, "test-uid")
+	assert.False(t, found, "An unreachable Redis should be a cache miss")
+	c.Set(ctx, "other-uid", testOrder("other-uid"))
+}
+
+func TestTiered(t *testing.T) {
+	shared, mr := newTestRedis(t, CodecJSON)
+	ctx := context.Background()
+
+	newLocal := func() *Bounded {
+		local, err := NewBounded(PolicyLRU, 10, 0, time.Minute, metrics.Nop{})
+		require.NoError(t, err)
+		return local
+	}
+	local1, local2 := newLocal(), newLocal()
+	replica1, replica2 := NewTiered(local1, shared), NewTiered(local2, shared)
+
+	order := testOrder("test-uid")
+	replica1.Set(ctx, "test-uid", order)
+	assert.True(t, mr.Exists("order:test-uid"))
+
+	// The second replica finds the order in Redis and keeps a local copy.
+	_, found := local2.Get(ctx, "test-uid")
+	assert.False(t, found)
+	val, found := replica2.Get(ctx, "test-uid")
+	require.True(t, found)
+	assert.Equal(t, "test-uid", val.OrderUID)
+	_, found = local2.Get(ctx, "test-uid")
+	assert.True(t, found)
+
+	// The local copy is served when Redis is gone.
+	mr.Close()
+	_, found = replica2.Get(ctx, "test-uid")
+	assert.True(t, found)
+}
+
+func TestNewFromConfig_Redis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.CacheConfig{
+		Backend:        BackendTiered,
+		Policy:         PolicyLRU,
+		MaxEntries:     10,
+		TTL:            time.Minute,
+		RedisAddr:      mr.Addr(),
+		RedisKeyPrefix: "order:",
+		RedisTimeout:   time.Second,
+		Codec:          CodecJSON,
+	}
+
+	c, err := NewFromConfig(cfg, metrics.Nop{}, logging.Nop())
+	require.NoError(t, err)
+	assert.IsType(t, &Tiered{}, c)
+	assert.Equal(t, time.Minute, c.(*Tiered).local.(*Bounded).ttl, "without LocalTTL, L1 uses TTL")
+	require.NoError(t, c.(*Tiered).Close())
+
+	cfg.LocalTTL = 10 * time.Second
+	c, err = NewFromConfig(cfg, metrics.Nop{}, logging.Nop())
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, c.(*Tiered).local.(*Bounded).ttl, "L1 keeps orders for LocalTTL only")
+	assert.Equal(t, time.Minute, c.(*Tiered).shared.(*Redis).ttl)
+	require.NoError(t, c.(*Tiered).Close())
+
+	cfg.Backend = BackendRedis
+	c, err = NewFromConfig(cfg, metrics.Nop{}, logging.Nop())
+	require.NoError(t, err)
+	assert.IsType(t, &Redis{}, c)
+	require.NoError(t, c.(*Redis).Close())
+
+	cfg.Codec = "xml"
+	_, err = NewFromConfig(cfg, metrics.Nop{}, logging.Nop())
+	assert.Error(t, err)
+
+	cfg.Codec = CodecJSON
+	mr.Close()
+	_, err = NewFromConfig(cfg, metrics.Nop{}, logging.Nop())
+	assert.Error(t, err, "Redis must be reachable at startup")
+
+	_, err = NewFromConfig(config.CacheConfig{Backend: "memcached"}, metrics.Nop{}, logging.Nop())
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"wildberries-tech/internal/models"
+)
+
+// Tiered is a two-level OrderCache: a local cache (L1) in front of a shared cache (L2).
+// Orders are written to both levels, and orders found only in L2 are copied to L1.
+// A replica only updates its own L1, so when a stored order is replaced (DB_CONFLICT_POLICY
+// "update"), other replicas serve the previous version until it expires from their L1.
+// NewFromConfig bounds this by giving L1 the short CacheConfig.LocalTTL.
+type Tiered struct {
+	local  OrderCache
+	shared OrderCache
+}
+
+// NewTiered creates a Tiered cache reading from local before shared.
+func NewTiered(local, shared OrderCache) *Tiered {
+	return &Tiered{local: local, shared: shared}
+}
+
+// Set stores an order in both levels.
+func (c *Tiered) Set(ctx context.Context, orderUID string, order models.Order) {
+	c.local.Set(ctx, orderUID, order)
+	c.shared.Set(ctx, orderUID, order)
+}
+
+// Get retrieves an order from the local cache, falling back to the shared cache.
+func (c *Tiered) Get(ctx context.Context, orderUID string) (models.Order, bool) {
+	if order, found := c.local.Get(ctx, orderUID); found {
+		return order, true
+	}
+	order, found := c.shared.Get(ctx, orderUID)
+	if found {
+		c.local.Set(ctx, orderUID, order)
+	}
+	return order, found
+}
+
+// LoadFromDB stores a list of orders in both levels.
+func (c *Tiered) LoadFromDB(ctx context.Context, orders []models.Order) {
+	c.local.LoadFromDB(ctx, orders)
+	c.shared.LoadFromDB(ctx, orders)
+}
+
+// Len returns the size of the shared cache, which holds every order of the local one.
+// If the shared cache cannot report its size, the size of the local cache is returned.
+func (c *Tiered) Len(ctx context.Context) (int, error) {
+	if sizer, ok := c.shared.(Sizer); ok {
+		return sizer.Len(ctx)
+	}
+	if sizer, ok := c.local.(Sizer); ok {
+		return sizer.Len(ctx)
+	}
+	return 0, errors.New("cache size is unknown")
+}
+
+// Close closes the shared cache if it holds a connection.
+func (c *Tiered) Close() error {
+	if closer, ok := c.shared.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/repository"
+)
+
+// warmUpProgressInterval is how often WarmUp logs its progress.
+const warmUpProgressInterval = 5 * time.Second
+
+// OrderSource pages through stored orders, newest first.
+type OrderSource interface {
+	ListOrders(ctx context.Context, filter repository.OrderFilter, after *repository.Cursor,
+		limit int) (repository.OrderPage, error)
+}
+
+// WarmUp loads the most recent orders from source into c one page at a time, so that only a single page
+// is held in memory besides the cache. It stops after cfg.WarmUpMaxOrders orders or at the first order
+// created more than cfg.WarmUpWindow ago; a zero value disables the respective bound.
+// It returns the number of orders loaded, which is also meaningful along with an error.
+func WarmUp(ctx context.Context, source OrderSource, c OrderCache, cfg config.CacheConfig,
+	logger *slog.Logger) (int, error) {
+	var filter repository.OrderFilter
+	if cfg.WarmUpWindow > 0 {
+		filter.CreatedFrom = time.Now().UTC().Add(-cfg.WarmUpWindow)
+	}
+	pageSize := max(cfg.WarmUpPageSize, 1)
+
+	start := time.Now()
+	lastLog := start
+	loaded := 0
+	var after *repository.Cursor
+
+	for cfg.WarmUpMaxOrders <= 0 || loaded < cfg.WarmUpMaxOrders {
+		limit := pageSize
+		if cfg.WarmUpMaxOrders > 0 {
+			limit = min(limit, cfg.WarmUpMaxOrders-loaded)
+		}
+
+		page, err := source.ListOrders(ctx, filter, after, limit)
+		if err != nil {
+			return loaded, fmt.Errorf("failed to load orders: %w", err)
+		}
+
+		c.LoadFromDB(ctx, page.Orders)
+		loaded += len(page.Orders)
+
+		if page.Next == nil {
+			break
+		}
+		after = page.Next
+
+		if time.Since(lastLog) >= warmUpProgressInterval {
+			logger.InfoContext(ctx, "Cache warm-up in progress", "loaded", loaded)
+			lastLog = time.Now()
+		}
+	}
+
+	logger.InfoContext(ctx, "Cache warm-up completed",
+		"loaded", loaded, "duration", time.Since(start).Round(time.Millisecond))
+	return loaded, nil
+}
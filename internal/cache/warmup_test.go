@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/metrics"
+	"wildberries-tech/internal/models"
+	"wildberries-tech/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSource serves total orders, newest first, recording the requests it receives.
+type fakeSource struct {
+	total    int
+	failAt   int
+	filters  []repository.OrderFilter
+	limits   []int
+	cursors  []*repository.Cursor
+	returned int
+}
+
+func (s *fakeSource) ListOrders(_ context.Context, filter repository.OrderFilter, after *repository.Cursor,
+	limit int) (repository.OrderPage, error) {
+	s.filters = append(s.filters, filter)
+	s.limits = append(s.limits, limit)
+	s.cursors = append(s.cursors, after)
+	if s.failAt > 0 && len(s.limits) == s.failAt {
+		return repository.OrderPage{}, errors.New("connection reset")
+	}
+
+	var page repository.OrderPage
+	for len(page.Orders) < limit && s.returned < s.total {
+		page.Orders = append(page.Orders, models.Order{OrderUID: fmt.Sprintf("uid-%d", s.returned)})
+		s.returned++
+	}
+	if s.returned < s.total {
+		page.Next = &repository.Cursor{OrderUID: fmt.Sprintf("uid-%d", s.returned-1)}
+	}
+	return page, nil
+}
+
+func TestWarmUp_StopsAtMaxOrders(t *testing.T) {
+	c := New(5*time.Minute, 10*time.Minute, metrics.Nop{})
+	source := &fakeSource{total: 100}
+
+	loaded, err := WarmUp(context.Background(), source, c, config.CacheConfig{
+		WarmUpMaxOrders: 25,
+		WarmUpPageSize:  10,
+	}, logging.Nop())
+	require.NoError(t, err)
+
+	assert.Equal(t, 25, loaded)
+	assert.Equal(t, []int{10, 10, 5}, source.limits, "the last page is trimmed to the remaining budget")
+	assert.Nil(t, source.cursors[0])
+	assert.Equal(t, "uid-9", source.cursors[1].OrderUID)
+	assert.True(t, source.filters[0].CreatedFrom.IsZero(), "no window configured")
+
+	_, found := c.Get(context.Background(), "uid-24")
+	assert.True(t, found)
+	_, found = c.Get(context.Background(), "uid-25")
+	assert.False(t, found)
+}
+
+func TestWarmUp_Window(t *testing.T) {
+	c := New(5*time.Minute, 10*time.Minute, metrics.Nop{})
+	source := &fakeSource{total: 15}
+
+	loaded, err := WarmUp(context.Background(), source, c, config.CacheConfig{
+		WarmUpWindow:   2 * time.Hour,
+		WarmUpPageSize: 10,
+	}, logging.Nop())
+	require.NoError(t, err)
+
+	assert.Equal(t, 15, loaded)
+	assert.Len(t, source.limits, 2)
+	assert.WithinDuration(t, time.Now().Add(-2*time.Hour), source.filters[0].CreatedFrom, time.Minute)
+	assert.Equal(t, time.UTC, source.filters[0].CreatedFrom.Location())
+}
+
+func TestWarmUp_Error(t *testing.T) {
+	c := New(5*time.Minute, 10*time.Minute, metrics.Nop{})
+	source := &fakeSource{total: 100, failAt: 2}
+
+	loaded, err := WarmUp(context.Background(), source, c, config.CacheConfig{WarmUpPageSize: 10}, logging.Nop())
+	require.Error(t, err)
+	assert.Equal(t, 10, loaded, "orders of the pages read before the failure stay cached")
+}
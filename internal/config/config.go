@@ -2,9 +2,11 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -17,14 +19,41 @@ type Config struct {
 	Server   ServerConfig
 	Cache    CacheConfig
 	Tracing  TracingConfig
+	Logging  LoggingConfig
+	Health   HealthConfig
 }
 
 // KafkaConfig holds configuration for Kafka.
 type KafkaConfig struct {
-	Brokers  []string
-	Topic    string
-	GroupID  string
-	DLQTopic string
+	Brokers           []string
+	Topic             string
+	GroupID           string
+	DLQTopic          string
+	RebalanceStrategy string
+	// ConsumerID identifies this service instance in the headers of dead-lettered messages.
+	ConsumerID string
+	// InitialOffset is where a group without committed offsets starts reading:
+	// "oldest", "newest" or "timestamp" (see InitialOffsetTime).
+	InitialOffset     string
+	InitialOffsetTime time.Time
+	// Transient save failures are retried up to RetryMaxAttempts times with an exponential
+	// backoff between RetryInitialBackoff and RetryMaxBackoff before the message is dead-lettered.
+	RetryMaxAttempts    int
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	// Fetching is paused after PauseFailureThreshold consecutive failed saves or while the
+	// database is reported down, and the pause is re-evaluated every PauseCheckInterval,
+	// which has to be positive.
+	PauseFailureThreshold int
+	PauseCheckInterval    time.Duration
+	// Messages are processed by WorkerPoolSize workers, each with a queue of WorkerQueueDepth
+	// messages. Messages with the same key are always handled by the same worker, in order.
+	WorkerPoolSize   int
+	WorkerQueueDepth int
+	// Each worker saves up to BatchSize queued orders in a single transaction, waiting at most
+	// BatchLinger for a batch to fill. A BatchSize of 1 saves every order on its own.
+	BatchSize   int
+	BatchLinger time.Duration
 }
 
 // ServerConfig holds configuration for the HTTP server.
@@ -33,10 +62,59 @@ type ServerConfig struct {
 	Port string
 }
 
-// CacheConfig holds configuration for the in-memory cache.
+// CacheConfig holds configuration for the order cache.
 type CacheConfig struct {
+	// Backend is "memory" for a cache local to the process, "redis" for a cache shared by all
+	// replicas through Redis, or "tiered" for a local cache in front of Redis.
+	Backend         string
 	TTL             time.Duration
 	CleanupInterval time.Duration
+	// Policy is "lru" or "lfu" for a cache bounded by MaxEntries entries and MaxBytes
+	// approximate bytes (zero disables a bound), or "ttl" for an unbounded cache.
+	Policy     string
+	MaxEntries int
+	MaxBytes   int64
+	// At startup the cache is warmed up with the newest WarmUpMaxOrders orders created within
+	// WarmUpWindow, read WarmUpPageSize orders at a time. Zero disables either bound.
+	WarmUpMaxOrders int
+	WarmUpWindow    time.Duration
+	WarmUpPageSize  int
+	// NegativeTTL is how long an order UID that was not found in the database is answered
+	// with "not found" without querying it again. Zero disables negative caching.
+	NegativeTTL time.Duration
+	// Orders are stored in Redis under RedisKeyPrefix followed by the order UID, encoded
+	// with Codec ("json" or "gob"). Each Redis command gives up after RedisTimeout.
+	RedisAddr      string
+	RedisPassword  string
+	RedisDB        int
+	RedisKeyPrefix string
+	RedisTimeout   time.Duration
+	Codec          string
+	// LocalTTL is the TTL of the local cache in the "tiered" backend, if shorter than TTL.
+	// It bounds how long a replica serves an order after another replica replaced it.
+	LocalTTL time.Duration
+}
+
+// LoggingConfig holds configuration for structured logging.
+type LoggingConfig struct {
+	// Level is the minimum level logged: "debug", "info", "warn" or "error".
+	Level string
+	// Format is "json" or "text".
+	Format string
+}
+
+// HealthConfig holds configuration for health checking.
+type HealthConfig struct {
+	// CheckInterval is how often components are checked, and CheckTimeout how long a single
+	// check may take.
+	CheckInterval time.Duration
+	CheckTimeout  time.Duration
+	// Critical lists the components ("database", "kafka", "consumer_group", "cache",
+	// "consumer_lag", "dlq_producer") without which the service is not ready. Any other
+	// component that is down only degrades the service.
+	Critical []string
+	// MaxConsumerLag is the number of unhandled messages above which the consumer lag check fails.
+	MaxConsumerLag int64
 }
 
 // TracingConfig holds configuration for distributed tracing.
@@ -55,48 +133,109 @@ type DatabaseConfig struct {
 	SSLMode    string
 	MaxRetries int
 	RetryDelay time.Duration
+	// ConflictPolicy decides what happens when an order is saved again with a different payload:
+	// "reject" returns an error, "update" replaces the stored order and its items.
+	ConflictPolicy string
 }
 
 // LoadConfig reads configuration from .env file or environment variables.
 func LoadConfig() (*Config, error) {
 	if err := godotenv.Load(); err != nil {
 		if os.IsNotExist(err) {
-			log.Println("No .env file found, using environment variables")
+			slog.Info("No .env file found, using environment variables")
 		} else {
 			return nil, fmt.Errorf("error loading .env file: %w", err)
 		}
 	}
 
-	return &Config{
+	cfg := &Config{
 		Database: DatabaseConfig{
-			Host:       getEnv("DB_HOST", "localhost"),
-			Port:       getEnv("DB_PORT", "5432"),
-			User:       getEnv("DB_USER", "postgres"),
-			Password:   getEnv("DB_PASSWORD", "postgres"),
-			DBName:     getEnv("DB_NAME", "wbtech"),
-			SSLMode:    getEnv("DB_SSLMODE", "disable"),
-			MaxRetries: getIntEnv("DB_MAX_RETRIES", 5),
-			RetryDelay: getDurationEnv("DB_RETRY_DELAY", 2*time.Second),
+			Host:           getEnv("DB_HOST", "localhost"),
+			Port:           getEnv("DB_PORT", "5432"),
+			User:           getEnv("DB_USER", "postgres"),
+			Password:       getEnv("DB_PASSWORD", "postgres"),
+			DBName:         getEnv("DB_NAME", "wbtech"),
+			SSLMode:        getEnv("DB_SSLMODE", "disable"),
+			MaxRetries:     getIntEnv("DB_MAX_RETRIES", 5),
+			RetryDelay:     getDurationEnv("DB_RETRY_DELAY", 2*time.Second),
+			ConflictPolicy: getEnv("DB_CONFLICT_POLICY", "reject"),
 		},
 		Kafka: KafkaConfig{
-			Brokers:  []string{getEnv("KAFKA_BROKERS", "localhost:9092")},
-			Topic:    getEnv("KAFKA_TOPIC", "orders"),
-			GroupID:  getEnv("KAFKA_GROUP_ID", "orders-service"),
-			DLQTopic: getEnv("KAFKA_DLQ_TOPIC", "orders-dlq"),
+			Brokers:           []string{getEnv("KAFKA_BROKERS", "localhost:9092")},
+			Topic:             getEnv("KAFKA_TOPIC", "orders"),
+			GroupID:           getEnv("KAFKA_GROUP_ID", "orders-service"),
+			DLQTopic:          getEnv("KAFKA_DLQ_TOPIC", "orders-dlq"),
+			RebalanceStrategy: getEnv("KAFKA_REBALANCE_STRATEGY", "sticky"),
+			ConsumerID:        getEnv("KAFKA_CONSUMER_ID", defaultInstanceID()),
+			InitialOffset:     getEnv("KAFKA_INITIAL_OFFSET", "newest"),
+			InitialOffsetTime: getTimeEnv("KAFKA_INITIAL_OFFSET_TIME", time.Time{}),
+
+			RetryMaxAttempts:    getIntEnv("KAFKA_RETRY_MAX_ATTEMPTS", 5),
+			RetryInitialBackoff: getDurationEnv("KAFKA_RETRY_INITIAL_BACKOFF", 200*time.Millisecond),
+			RetryMaxBackoff:     getDurationEnv("KAFKA_RETRY_MAX_BACKOFF", 5*time.Second),
+
+			PauseFailureThreshold: getIntEnv("KAFKA_PAUSE_FAILURE_THRESHOLD", 3),
+			PauseCheckInterval:    getDurationEnv("KAFKA_PAUSE_CHECK_INTERVAL", 5*time.Second),
+
+			WorkerPoolSize:   getIntEnv("KAFKA_WORKER_POOL_SIZE", 8),
+			WorkerQueueDepth: getIntEnv("KAFKA_WORKER_QUEUE_DEPTH", 100),
+			BatchSize:        getIntEnv("KAFKA_BATCH_SIZE", 50),
+			BatchLinger:      getDurationEnv("KAFKA_BATCH_LINGER", 20*time.Millisecond),
 		},
 		Server: ServerConfig{
 			Host: getEnv("SERVER_HOST", "0.0.0.0"),
 			Port: getEnv("SERVER_PORT", "8081"),
 		},
 		Cache: CacheConfig{
+			Backend:         getEnv("CACHE_BACKEND", "memory"),
 			TTL:             getDurationEnv("CACHE_TTL", 5*time.Minute),
 			CleanupInterval: getDurationEnv("CACHE_CLEANUP_INTERVAL", 10*time.Minute),
+			Policy:          getEnv("CACHE_POLICY", "lru"),
+			MaxEntries:      getIntEnv("CACHE_MAX_ENTRIES", 100000),
+			MaxBytes:        int64(getIntEnv("CACHE_MAX_BYTES", 0)),
+			WarmUpMaxOrders: getIntEnv("CACHE_WARMUP_MAX_ORDERS", 10000),
+			WarmUpWindow:    getDurationEnv("CACHE_WARMUP_WINDOW", 24*time.Hour),
+			WarmUpPageSize:  getIntEnv("CACHE_WARMUP_PAGE_SIZE", 500),
+			NegativeTTL:     getDurationEnv("CACHE_NEGATIVE_TTL", 5*time.Second),
+			RedisAddr:       getEnv("REDIS_ADDR", "localhost:6379"),
+			RedisPassword:   getEnv("REDIS_PASSWORD", ""),
+			RedisDB:         getIntEnv("REDIS_DB", 0),
+			RedisKeyPrefix:  getEnv("REDIS_KEY_PREFIX", "order:"),
+			RedisTimeout:    getDurationEnv("REDIS_TIMEOUT", 200*time.Millisecond),
+			Codec:           getEnv("CACHE_CODEC", "json"),
+			LocalTTL:        getDurationEnv("CACHE_LOCAL_TTL", 10*time.Second),
+		},
+		Logging: LoggingConfig{
+			Level:  getEnv("LOG_LEVEL", "info"),
+			Format: getEnv("LOG_FORMAT", "json"),
+		},
+		Health: HealthConfig{
+			CheckInterval:  getDurationEnv("HEALTH_CHECK_INTERVAL", 30*time.Second),
+			CheckTimeout:   getDurationEnv("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+			Critical:       getListEnv("HEALTH_CRITICAL", []string{"database"}),
+			MaxConsumerLag: int64(getIntEnv("HEALTH_MAX_CONSUMER_LAG", 10000)),
 		},
 		Tracing: TracingConfig{
 			Enabled:  getBoolEnv("TRACING_ENABLED", false),
 			Endpoint: getEnv("TRACING_ENDPOINT", "localhost:4318"),
 		},
-	}, nil
+	}
+	if err := cfg.Kafka.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate reports settings of the Kafka consumer that cannot work together.
+func (c *KafkaConfig) Validate() error {
+	if c.InitialOffset == "timestamp" && c.InitialOffsetTime.IsZero() {
+		return errors.New("KAFKA_INITIAL_OFFSET=timestamp requires KAFKA_INITIAL_OFFSET_TIME to be set to an RFC3339 time")
+	}
+	// A paused consumer is only resumed by the periodic check.
+	if c.PauseCheckInterval <= 0 {
+		return fmt.Errorf("KAFKA_PAUSE_CHECK_INTERVAL must be positive, got %s", c.PauseCheckInterval)
+	}
+	return nil
 }
 
 // DSN returns the PostgreSQL Data Source Name.
@@ -105,6 +244,15 @@ func (c *DatabaseConfig) DSN() string {
 		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode)
 }
 
+// defaultInstanceID identifies the running process by host name and process ID.
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -118,7 +266,7 @@ func getIntEnv(key string, defaultValue int) int {
 		if _, err := fmt.Sscanf(value, "%d", &intValue); err == nil {
 			return intValue
 		}
-		log.Printf("Invalid integer for %s, using default: %v", key, defaultValue)
+		slog.Warn("Invalid integer, using default", "key", key, "default", defaultValue)
 	}
 	return defaultValue
 }
@@ -128,11 +276,36 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 		if duration, err := time.ParseDuration(value); err == nil {
 			return duration
 		}
-		log.Printf("Invalid duration for %s, using default: %v", key, defaultValue)
+		slog.Warn("Invalid duration, using default", "key", key, "default", defaultValue)
+	}
+	return defaultValue
+}
+
+func getTimeEnv(key string, defaultValue time.Time) time.Time {
+	if value, exists := os.LookupEnv(key); exists {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t
+		}
+		slog.Warn("Invalid RFC3339 time, using default", "key", key, "default", defaultValue)
 	}
 	return defaultValue
 }
 
+// getListEnv returns the comma-separated, non-empty elements of an environment variable.
+func getListEnv(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	var list []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
 func getBoolEnv(key string, defaultValue bool) bool {
 	if value, exists := os.LookupEnv(key); exists {
 		return value == "true" || value == "1" || value == "yes"
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validKafkaConfig() KafkaConfig {
+	return KafkaConfig{InitialOffset: "newest", PauseCheckInterval: 5 * time.Second}
+}
+
+func TestKafkaConfig_Validate(t *testing.T) {
+	require.NoError(t, (&KafkaConfig{InitialOffset: "timestamp", InitialOffsetTime: time.Now(),
+		PauseCheckInterval: time.Second}).Validate())
+
+	for name, mutate := range map[string]func(*KafkaConfig){
+		"timestamp without time":  func(c *KafkaConfig) { c.InitialOffset = "timestamp" },
+		"zero pause interval":     func(c *KafkaConfig) { c.PauseCheckInterval = 0 },
+		"negative pause interval": func(c *KafkaConfig) { c.PauseCheckInterval = -time.Second },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := validKafkaConfig()
+			mutate(&cfg)
+			assert.Error(t, cfg.Validate())
+		})
+	}
+}
+
+func TestLoadConfig_RejectsZeroPauseInterval(t *testing.T) {
+	t.Setenv("KAFKA_PAUSE_CHECK_INTERVAL", "0s")
+	_, err := LoadConfig()
+	assert.EqualError(t, err, "KAFKA_PAUSE_CHECK_INTERVAL must be positive, got 0s")
+
+	t.Setenv("KAFKA_PAUSE_CHECK_INTERVAL", "1s")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, cfg.Kafka.PauseCheckInterval)
+}
@@ -2,52 +2,316 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 	"wildberries-tech/internal/cache"
+	"wildberries-tech/internal/metrics"
+	"wildberries-tech/internal/models"
 	"wildberries-tech/internal/repository"
 
 	"github.com/gorilla/mux"
 )
 
+// Page sizes of the order listing.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+const (
+	// loadTimeout bounds a database lookup shared by concurrent requests. The lookup does not
+	// end with the request that started it, but with the last request waiting for its result.
+	loadTimeout = 5 * time.Second
+	// notFoundCacheSize bounds the number of unknown order UIDs remembered at a time.
+	notFoundCacheSize = 10000
+)
+
 // Handler manages HTTP requests and dependencies.
 type Handler struct {
-	repo  repository.OrderRepository
-	cache cache.OrderCache
+	repo    repository.OrderRepository
+	cache   cache.OrderCache
+	metrics metrics.Metrics
+	logger  *slog.Logger
+
+	// loads holds the database lookups in progress by order UID, so that concurrent
+	// lookups of the same order share one.
+	loadsMu sync.Mutex
+	loads   map[string]*load
+	// joined, if set, is called whenever a request starts waiting for a lookup. Tests use it.
+	joined func(orderUID string)
+	// notFound remembers UIDs that were not found in the database; nil if disabled.
+	notFound *cache.Bounded
 }
 
-// New creates a new Handler instance.
-func New(repo repository.OrderRepository, cache cache.OrderCache) *Handler {
-	return &Handler{
-		repo:  repo,
-		cache: cache,
+// New creates a new Handler instance. Order UIDs that are not found in the database are
+// answered from memory for notFoundTTL; zero disables this negative caching.
+func New(repo repository.OrderRepository, c cache.OrderCache, m metrics.Metrics,
+	notFoundTTL time.Duration, logger *slog.Logger) *Handler {
+	h := &Handler{
+		repo:    repo,
+		cache:   c,
+		metrics: m,
+		logger:  logger,
+		loads:   make(map[string]*load),
+	}
+	if notFoundTTL > 0 {
+		// The policy is always valid, so NewBounded cannot fail.
+		h.notFound, _ = cache.NewBounded(cache.PolicyLRU, notFoundCacheSize, 0, notFoundTTL, metrics.Nop{})
 	}
+	return h
 }
 
-// GetOrder handles requests to retrieve an order by UID.
+// GetOrder handles requests to retrieve an order by UID. Malformed UIDs are rejected
+// before the cache and the database are consulted.
 func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	orderUID := vars["order_uid"]
+	if !validOrderUID.MatchString(orderUID) {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidOrderUID,
+			"order_uid must be 1 to 255 letters, digits, '-' or '_'")
+		return
+	}
 
-	order, exists := h.cache.Get(orderUID)
+	order, exists := h.cache.Get(r.Context(), orderUID)
 	if exists {
+		h.metrics.IncCacheHits()
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(order); err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			writeProblem(w, r, http.StatusInternalServerError, codeInternalError, "Failed to encode response")
 		}
 		return
 	}
 
-	orderPtr, err := h.repo.GetOrder(orderUID)
+	h.metrics.IncCacheMisses()
+
+	order, err := h.loadOrder(r.Context(), orderUID)
 	if err != nil {
-		http.Error(w, "Order not found", http.StatusNotFound)
+		h.writeError(w, r, err)
 		return
 	}
 
-	h.cache.Set(orderUID, *orderPtr)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(order); err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, codeInternalError, "Failed to encode response")
+	}
+}
+
+// loadOrder reads an order that is not cached from the database and caches it. Concurrent
+// loads of the same order share a single query, and an order that does not exist is
+// reported as repository.ErrOrderNotFound without a query for a while.
+func (h *Handler) loadOrder(ctx context.Context, orderUID string) (models.Order, error) {
+	if h.notFound != nil {
+		if _, found := h.notFound.Get(ctx, orderUID); found {
+			return models.Order{}, fmt.Errorf("%w: %s", repository.ErrOrderNotFound, orderUID)
+		}
+	}
+
+	l := h.joinLoad(ctx, orderUID)
+	select {
+	case <-l.done:
+		return l.order, l.err
+	case <-ctx.Done():
+		h.leaveLoad(orderUID, l)
+		return models.Order{}, ctx.Err()
+	}
+}
+
+// load is a database lookup of an order shared by the requests waiting for it.
+type load struct {
+	done    chan struct{}
+	order   models.Order
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// joinLoad returns the lookup of orderUID in progress, starting one if there is none, and
+// counts the caller as waiting for it. The lookup is not bound to ctx, since it may outlive
+// the request that started it; it is cancelled once no request waits for it anymore.
+func (h *Handler) joinLoad(ctx context.Context, orderUID string) *load {
+	h.loadsMu.Lock()
+	l, ok := h.loads[orderUID]
+	if !ok {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		l = &load{done: make(chan struct{}), cancel: cancel}
+		h.loads[orderUID] = l
+		go h.runLoad(loadCtx, orderUID, l)
+	}
+	l.waiters++
+	h.loadsMu.Unlock()
+
+	if h.joined != nil {
+		h.joined(orderUID)
+	}
+	return l
+}
+
+// leaveLoad stops waiting for a lookup and cancels it if no other request waits for it.
+func (h *Handler) leaveLoad(orderUID string, l *load) {
+	h.loadsMu.Lock()
+	defer h.loadsMu.Unlock()
+	l.waiters--
+	if l.waiters > 0 {
+		return
+	}
+	l.cancel()
+	if h.loads[orderUID] == l {
+		delete(h.loads, orderUID)
+	}
+}
+
+// runLoad queries the database for an order, caches the result and hands it to the waiting requests.
+func (h *Handler) runLoad(ctx context.Context, orderUID string, l *load) {
+	defer l.cancel()
+
+	order, err := h.repo.GetOrder(ctx, orderUID)
+	if err != nil {
+		if repository.IsNotFound(err) && h.notFound != nil {
+			h.notFound.Set(ctx, orderUID, models.Order{})
+		}
+		l.err = err
+	} else {
+		h.cache.Set(ctx, orderUID, *order)
+		l.order = *order
+	}
+
+	h.loadsMu.Lock()
+	if h.loads[orderUID] == l {
+		delete(h.loads, orderUID)
+	}
+	h.loadsMu.Unlock()
+	close(l.done)
+}
+
+// orderList is the response body of ListOrders.
+type orderList struct {
+	Orders     []models.Order `json:"orders"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// ListOrders handles requests to browse orders, newest first. The query parameters customer_id,
+// delivery_service, payment.provider, payment.currency and locale filter by exact value, and
+// date_from and date_to (RFC 3339) bound date_created. A page holds up to limit orders; the
+// next_cursor of a response is passed as cursor to fetch the following page.
+func (h *Handler) ListOrders(w http.ResponseWriter, r *http.Request) {
+	h.listOrders(w, r, "")
+}
+
+// ListCustomerOrders handles requests to browse the orders of the customer in the path.
+// It accepts the same query parameters as ListOrders, except customer_id.
+func (h *Handler) ListCustomerOrders(w http.ResponseWriter, r *http.Request) {
+	h.listOrders(w, r, mux.Vars(r)["customer_id"])
+}
+
+// listOrders serves a page of orders, restricted to customerID unless it is empty.
+func (h *Handler) listOrders(w http.ResponseWriter, r *http.Request, customerID string) {
+	query := r.URL.Query()
+
+	filter := repository.OrderFilter{
+		CustomerID:      query.Get("customer_id"),
+		DeliveryService: query.Get("delivery_service"),
+		PaymentProvider: query.Get("payment.provider"),
+		PaymentCurrency: query.Get("payment.currency"),
+		Locale:          query.Get("locale"),
+	}
+	if customerID != "" {
+		filter.CustomerID = customerID
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseTimeParam(query.Get("date_from")); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid date_from: "+err.Error())
+		return
+	}
+	if filter.CreatedTo, err = parseTimeParam(query.Get("date_to")); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid date_to: "+err.Error())
+		return
+	}
+
+	limit := defaultPageSize
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter,
+				fmt.Sprintf("Invalid limit: must be between 1 and %d", maxPageSize))
+			return
+		}
+	}
+
+	var after *repository.Cursor
+	if token := query.Get("cursor"); token != "" {
+		cursor, err := repository.DecodeCursor(token)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid cursor")
+			return
+		}
+		after = &cursor
+	}
+
+	page, err := h.repo.ListOrders(r.Context(), filter, after, limit)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := orderList{Orders: page.Orders}
+	if response.Orders == nil {
+		response.Orders = []models.Order{}
+	}
+	if page.Next != nil {
+		response.NextCursor = page.Next.Encode()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(*orderPtr); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, codeInternalError, "Failed to encode response")
+	}
+}
+
+// FindByTrackNumber handles requests for the orders with the track number in the path,
+// either as their own track number or as the track number of one of their items.
+func (h *Handler) FindByTrackNumber(w http.ResponseWriter, r *http.Request) {
+	h.findOrders(w, r, h.repo.FindOrdersByTrackNumber, mux.Vars(r)["track_number"])
+}
+
+// FindByTransaction handles requests for the orders paid with the transaction in the path.
+func (h *Handler) FindByTransaction(w http.ResponseWriter, r *http.Request) {
+	h.findOrders(w, r, h.repo.FindOrdersByTransaction, mux.Vars(r)["transaction"])
+}
+
+// FindByRID handles requests for the orders containing an item with the rid in the path.
+func (h *Handler) FindByRID(w http.ResponseWriter, r *http.Request) {
+	h.findOrders(w, r, h.repo.FindOrdersByRID, mux.Vars(r)["rid"])
+}
+
+// findOrders serves the orders returned by a repository lookup, or 404 if there are none.
+func (h *Handler) findOrders(w http.ResponseWriter, r *http.Request,
+	find func(ctx context.Context, value string) ([]models.Order, error), value string) {
+	orders, err := find(r.Context(), value)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if len(orders) == 0 {
+		writeProblem(w, r, http.StatusNotFound, codeOrderNotFound, "No orders match "+value)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(orderList{Orders: orders}); err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, codeInternalError, "Failed to encode response")
+	}
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
 	}
+	return time.Parse(time.RFC3339, value)
 }
@@ -1,28 +1,44 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/metrics"
 	"wildberries-tech/internal/models"
+	"wildberries-tech/internal/repository"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
 type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) SaveOrder(order models.Order) error {
+func (m *MockRepository) SaveOrder(_ context.Context, order models.Order) error {
 	args := m.Called(order)
 	return args.Error(0)
 }
 
-func (m *MockRepository) GetOrder(orderUID string) (*models.Order, error) {
+func (m *MockRepository) SaveOrders(_ context.Context, orders []models.Order) error {
+	args := m.Called(orders)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetOrder(_ context.Context, orderUID string) (*models.Order, error) {
 	args := m.Called(orderUID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -30,8 +46,24 @@ func (m *MockRepository) GetOrder(orderUID string) (*models.Order, error) {
 	return args.Get(0).(*models.Order), args.Error(1)
 }
 
-func (m *MockRepository) GetAllOrders() ([]models.Order, error) {
-	args := m.Called()
+func (m *MockRepository) ListOrders(_ context.Context, filter repository.OrderFilter, after *repository.Cursor,
+	limit int) (repository.OrderPage, error) {
+	args := m.Called(filter, after, limit)
+	return args.Get(0).(repository.OrderPage), args.Error(1)
+}
+
+func (m *MockRepository) FindOrdersByTrackNumber(_ context.Context, trackNumber string) ([]models.Order, error) {
+	args := m.Called(trackNumber)
+	return args.Get(0).([]models.Order), args.Error(1)
+}
+
+func (m *MockRepository) FindOrdersByTransaction(_ context.Context, transaction string) ([]models.Order, error) {
+	args := m.Called(transaction)
+	return args.Get(0).([]models.Order), args.Error(1)
+}
+
+func (m *MockRepository) FindOrdersByRID(_ context.Context, rid string) ([]models.Order, error) {
+	args := m.Called(rid)
 	return args.Get(0).([]models.Order), args.Error(1)
 }
 
@@ -52,27 +84,43 @@ type MockCache struct {
 	mock.Mock
 }
 
-func (m *MockCache) Set(orderUID string, order models.Order) {
+func (m *MockCache) Set(_ context.Context, orderUID string, order models.Order) {
 	m.Called(orderUID, order)
 }
 
-func (m *MockCache) Get(orderUID string) (models.Order, bool) {
+func (m *MockCache) Get(_ context.Context, orderUID string) (models.Order, bool) {
 	args := m.Called(orderUID)
 	return args.Get(0).(models.Order), args.Bool(1)
 }
 
-func (m *MockCache) LoadFromDB(orders []models.Order) {
+func (m *MockCache) LoadFromDB(_ context.Context, orders []models.Order) {
 	m.Called(orders)
 }
 
+// MockMetrics records the cache metrics emitted by the handler.
+type MockMetrics struct {
+	metrics.Nop
+	mock.Mock
+}
+
+func (m *MockMetrics) IncCacheHits() {
+	m.Called()
+}
+
+func (m *MockMetrics) IncCacheMisses() {
+	m.Called()
+}
+
 func TestGetOrder_CacheHit(t *testing.T) {
 	mockRepo := new(MockRepository)
 	mockCache := new(MockCache)
 
 	order := models.Order{OrderUID: "test-uid", TrackNumber: "TRACK123"}
 	mockCache.On("Get", "test-uid").Return(order, true)
+	mockMetrics := new(MockMetrics)
+	mockMetrics.On("IncCacheHits").Return()
 
-	h := New(mockRepo, mockCache)
+	h := New(mockRepo, mockCache, mockMetrics, 0, logging.Nop())
 
 	req, _ := http.NewRequest("GET", "/order/test-uid", nil)
 	rr := httptest.NewRecorder()
@@ -90,6 +138,7 @@ func TestGetOrder_CacheHit(t *testing.T) {
 	assert.Equal(t, "test-uid", response.OrderUID)
 
 	mockCache.AssertExpectations(t)
+	mockMetrics.AssertExpectations(t)
 }
 
 func TestGetOrder_CacheMiss_DBHit(t *testing.T) {
@@ -100,8 +149,10 @@ func TestGetOrder_CacheMiss_DBHit(t *testing.T) {
 	mockCache.On("Get", "test-uid").Return(models.Order{}, false)
 	mockRepo.On("GetOrder", "test-uid").Return(&order, nil)
 	mockCache.On("Set", "test-uid", order).Return()
+	mockMetrics := new(MockMetrics)
+	mockMetrics.On("IncCacheMisses").Return()
 
-	h := New(mockRepo, mockCache)
+	h := New(mockRepo, mockCache, mockMetrics, 0, logging.Nop())
 
 	req, _ := http.NewRequest("GET", "/order/test-uid", nil)
 	rr := httptest.NewRecorder()
@@ -114,4 +165,288 @@ func TestGetOrder_CacheMiss_DBHit(t *testing.T) {
 
 	mockCache.AssertExpectations(t)
 	mockRepo.AssertExpectations(t)
+	mockMetrics.AssertExpectations(t)
+}
+
+func TestGetOrder_ConcurrentMissesShareQuery(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockCache := new(MockCache)
+	mockMetrics := new(MockMetrics)
+
+	const requests = 10
+	// The query is held until every request waits for it.
+	var joined sync.WaitGroup
+	joined.Add(requests)
+
+	order := models.Order{OrderUID: "test-uid", TrackNumber: "TRACK123"}
+	mockCache.On("Get", "test-uid").Return(models.Order{}, false)
+	mockMetrics.On("IncCacheMisses").Return()
+	mockRepo.On("GetOrder", "test-uid").Run(func(mock.Arguments) { joined.Wait() }).Return(&order, nil).Once()
+	mockCache.On("Set", "test-uid", order).Return()
+
+	h := New(mockRepo, mockCache, mockMetrics, 0, logging.Nop())
+	h.joined = func(string) { joined.Done() }
+	router := mux.NewRouter()
+	router.HandleFunc("/order/{order_uid}", h.GetOrder)
+
+	codes := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			req := httptest.NewRequest("GET", "/order/test-uid", nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			codes <- rr.Code
+		}()
+	}
+
+	for i := 0; i < requests; i++ {
+		assert.Equal(t, http.StatusOK, <-codes)
+	}
+	mockRepo.AssertNumberOfCalls(t, "GetOrder", 1)
+	mockMetrics.AssertNumberOfCalls(t, "IncCacheMisses", requests)
+}
+
+// blockingRepo hands the context of every order lookup to the test and blocks until it is done.
+type blockingRepo struct {
+	MockRepository
+	lookups chan context.Context
+}
+
+func (r *blockingRepo) GetOrder(ctx context.Context, _ string) (*models.Order, error) {
+	r.lookups <- ctx
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGetOrder_CancelledRequestStopsQuery(t *testing.T) {
+	repo := &blockingRepo{lookups: make(chan context.Context, 1)}
+	mockCache := new(MockCache)
+	mockCache.On("Get", "test-uid").Return(models.Order{}, false)
+
+	h := New(repo, mockCache, metrics.Nop{}, time.Minute, logging.Nop())
+	router := mux.NewRouter()
+	router.HandleFunc("/order/{order_uid}", h.GetOrder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/order/test-uid", nil).WithContext(ctx))
+	}()
+
+	lookup := <-repo.lookups
+	cancel()
+	select {
+	case <-lookup.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the query outlived the only request waiting for it")
+	}
+	assert.ErrorIs(t, lookup.Err(), context.Canceled)
+	<-served
+}
+
+func TestGetOrder_NotFoundIsCached(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockCache := new(MockCache)
+
+	mockCache.On("Get", "missing").Return(models.Order{}, false)
+	mockRepo.On("GetOrder", "missing").Return(nil, fmt.Errorf("%w: missing", repository.ErrOrderNotFound))
+
+	h := New(mockRepo, mockCache, metrics.Nop{}, time.Minute, logging.Nop())
+	router := mux.NewRouter()
+	router.HandleFunc("/order/{order_uid}", h.GetOrder)
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/order/missing", nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	}
+	mockRepo.AssertNumberOfCalls(t, "GetOrder", 1)
+}
+
+func TestGetOrder_TransientErrorIsNotCached(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockCache := new(MockCache)
+
+	mockCache.On("Get", "test-uid").Return(models.Order{}, false)
+	mockRepo.On("GetOrder", "test-uid").Return(nil, driver.ErrBadConn)
+
+	h := New(mockRepo, mockCache, metrics.Nop{}, time.Minute, logging.Nop())
+	router := mux.NewRouter()
+	router.HandleFunc("/order/{order_uid}", h.GetOrder)
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/order/test-uid", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	}
+	mockRepo.AssertNumberOfCalls(t, "GetOrder", 2)
+}
+
+func TestGetOrder_Problems(t *testing.T) {
+	tests := []struct {
+		name       string
+		uid        string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"not found", "missing", fmt.Errorf("%w: missing", repository.ErrOrderNotFound), http.StatusNotFound, codeOrderNotFound},
+		{"record not found", "missing", gorm.ErrRecordNotFound, http.StatusNotFound, codeOrderNotFound},
+		{"timeout", "test-uid", context.DeadlineExceeded, http.StatusServiceUnavailable, codeServiceUnavailable},
+		{"internal", "test-uid", errors.New("unexpected"), http.StatusInternalServerError, codeInternalError},
+		{"malformed uid", "bad.uid", nil, http.StatusBadRequest, codeInvalidOrderUID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			mockCache := new(MockCache)
+			mockCache.On("Get", mock.Anything).Return(models.Order{}, false)
+			mockRepo.On("GetOrder", mock.Anything).Return(nil, tt.err)
+
+			h := New(mockRepo, mockCache, metrics.Nop{}, 0, logging.Nop())
+			router := mux.NewRouter()
+			router.HandleFunc("/order/{order_uid}", h.GetOrder)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/order/"+tt.uid, nil))
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+			var body problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+			assert.Equal(t, tt.wantStatus, body.Status)
+			assert.Equal(t, tt.wantCode, body.Code)
+			assert.Equal(t, "/order/"+tt.uid, body.Instance)
+
+			if tt.wantStatus == http.StatusServiceUnavailable {
+				assert.Equal(t, "5", rr.Header().Get("Retry-After"))
+			} else {
+				assert.Empty(t, rr.Header().Get("Retry-After"))
+			}
+			if tt.err == nil {
+				mockRepo.AssertNotCalled(t, "GetOrder", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestGetOrder_ClientGone(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockCache := new(MockCache)
+	mockCache.On("Get", "test-uid").Return(models.Order{}, false)
+	mockRepo.On("GetOrder", "test-uid").Return(nil, context.Canceled).Maybe()
+
+	h := New(mockRepo, mockCache, metrics.Nop{}, 0, logging.Nop())
+	router := mux.NewRouter()
+	router.HandleFunc("/order/{order_uid}", h.GetOrder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/order/test-uid", nil).WithContext(ctx))
+
+	assert.Equal(t, statusClientClosedRequest, rr.Code)
+	assert.Empty(t, rr.Body.String(), "no problem is written for a client that went away")
+}
+
+func TestListOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockCache := new(MockCache)
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	after := repository.Cursor{DateCreated: from.Add(48 * time.Hour), OrderUID: "uid-9"}
+	next := repository.Cursor{DateCreated: from.Add(24 * time.Hour), OrderUID: "uid-7"}
+
+	filter := repository.OrderFilter{
+		CustomerID:      "customer-1",
+		PaymentProvider: "wbpay",
+		CreatedFrom:     from,
+	}
+	page := repository.OrderPage{
+		Orders: []models.Order{{OrderUID: "uid-8"}, {OrderUID: "uid-7"}},
+		Next:   &next,
+	}
+	mockRepo.On("ListOrders", filter, &after, 2).Return(page, nil)
+
+	h := New(mockRepo, mockCache, metrics.Nop{}, 0, logging.Nop())
+
+	req, _ := http.NewRequest("GET", "/orders?customer_id=customer-1&payment.provider=wbpay"+
+		"&date_from=2024-05-01T00:00:00Z&limit=2&cursor="+after.Encode(), nil)
+	rr := httptest.NewRecorder()
+	h.ListOrders(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response orderList
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, response.Orders, 2)
+	assert.Equal(t, next.Encode(), response.NextCursor)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestListOrders_InvalidParameters(t *testing.T) {
+	h := New(new(MockRepository), new(MockCache), metrics.Nop{}, 0, logging.Nop())
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=ten", "date_from=yesterday", "date_to=2024-05-01", "cursor=!!!"} {
+		req, _ := http.NewRequest("GET", "/orders?"+query, nil)
+		rr := httptest.NewRecorder()
+		h.ListOrders(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+		assert.Contains(t, rr.Body.String(), `"code":"invalid_parameter"`, query)
+	}
+}
+
+func TestFindByTrackNumber(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("FindOrdersByTrackNumber", "WBILMTESTTRACK").
+		Return([]models.Order{{OrderUID: "test-uid", TrackNumber: "WBILMTESTTRACK"}}, nil)
+	mockRepo.On("FindOrdersByTransaction", "unknown").Return([]models.Order(nil), nil)
+
+	h := New(mockRepo, new(MockCache), metrics.Nop{}, 0, logging.Nop())
+	router := mux.NewRouter()
+	router.HandleFunc("/orders/by-track/{track_number}", h.FindByTrackNumber)
+	router.HandleFunc("/orders/by-transaction/{transaction}", h.FindByTransaction)
+
+	req, _ := http.NewRequest("GET", "/orders/by-track/WBILMTESTTRACK", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response orderList
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "test-uid", response.Orders[0].OrderUID)
+
+	req, _ = http.NewRequest("GET", "/orders/by-transaction/unknown", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestListCustomerOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	filter := repository.OrderFilter{CustomerID: "customer-1", Locale: "en"}
+	mockRepo.On("ListOrders", filter, (*repository.Cursor)(nil), defaultPageSize).
+		Return(repository.OrderPage{}, nil)
+
+	h := New(mockRepo, new(MockCache), metrics.Nop{}, 0, logging.Nop())
+	router := mux.NewRouter()
+	router.HandleFunc("/customers/{customer_id}/orders", h.ListCustomerOrders)
+
+	req, _ := http.NewRequest("GET", "/customers/customer-1/orders?customer_id=other&locale=en", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"orders":[]}`, rr.Body.String())
+	mockRepo.AssertExpectations(t)
 }
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/metrics"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// httpMetrics records the HTTP metrics it receives.
+type httpMetrics struct {
+	metrics.Nop
+	requests []string
+	sizes    map[string]int
+	inFlight int
+	peak     int
+}
+
+func (m *httpMetrics) IncHTTPRequests(method, path, status string) {
+	m.requests = append(m.requests, method+" "+path+" "+status)
+}
+
+func (m *httpMetrics) ObserveHTTPResponseSize(_, path string, bytes int) {
+	m.sizes[path] += bytes
+}
+
+func (m *httpMetrics) IncHTTPInFlight() {
+	m.inFlight++
+	m.peak = max(m.peak, m.inFlight)
+}
+
+func (m *httpMetrics) DecHTTPInFlight() { m.inFlight-- }
+
+func TestMetricsMiddleware(t *testing.T) {
+	m := &httpMetrics{sizes: make(map[string]int)}
+
+	router := mux.NewRouter()
+	router.Use(MetricsMiddleware(m))
+	router.HandleFunc("/order/{order_uid}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["order_uid"] == "missing" {
+			http.Error(w, "nope", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("order"))
+	})
+
+	for _, path := range []string{"/order/a", "/order/b", "/order/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, []string{
+		"GET /order/{order_uid} 200",
+		"GET /order/{order_uid} 200",
+		"GET /order/{order_uid} 404",
+	}, m.requests)
+	assert.Equal(t, len("order")*2+len("nope\n"), m.sizes["/order/{order_uid}"])
+	assert.Equal(t, 0, m.inFlight)
+	assert.Equal(t, 1, m.peak)
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/order/1", nil)
+	req.Header.Set(RequestIDHeader, "client-id-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "client-id-1", seen, "a valid client ID is kept")
+	assert.Equal(t, "client-id-1", rr.Header().Get(RequestIDHeader))
+
+	req = httptest.NewRequest(http.MethodGet, "/order/1", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.NotEqual(t, "bad id\n", seen, "an invalid client ID is replaced")
+	assert.Len(t, seen, 16)
+	assert.Equal(t, seen, rr.Header().Get(RequestIDHeader))
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/repository"
+)
+
+// Stable error codes reported in the code member of a problem.
+const (
+	codeOrderNotFound      = "order_not_found"
+	codeInvalidOrderUID    = "invalid_order_uid"
+	codeInvalidParameter   = "invalid_parameter"
+	codeServiceUnavailable = "service_unavailable"
+	codeInternalError      = "internal_error"
+)
+
+// statusClientClosedRequest is recorded, following nginx, for requests whose client went away
+// before the response was ready.
+const statusClientClosedRequest = 499
+
+// retryAfter is suggested to clients of a temporarily unavailable service.
+const retryAfter = 5 * time.Second
+
+// validOrderUID matches the order UIDs accepted in request paths.
+var validOrderUID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,255}$`)
+
+// problem is an RFC 7807 problem details object, extended with a stable error code.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// writeProblem responds with an application/problem+json body describing an error.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
+
+// writeError responds with the problem matching a repository error: 404 if the order does
+// not exist, 503 with Retry-After if the database is temporarily unavailable, and 500 otherwise.
+// Errors other than a missing order are logged. If the client has gone away, only the status
+// is set, since nobody reads the body.
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case r.Context().Err() != nil:
+		h.logger.DebugContext(r.Context(), "Request cancelled by client", "path", r.URL.Path, logging.Err(err))
+		w.WriteHeader(statusClientClosedRequest)
+	case repository.IsNotFound(err):
+		writeProblem(w, r, http.StatusNotFound, codeOrderNotFound, "Order not found")
+	case repository.IsTransient(err):
+		h.logger.WarnContext(r.Context(), "Order store unavailable", "path", r.URL.Path, logging.Err(err))
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		writeProblem(w, r, http.StatusServiceUnavailable, codeServiceUnavailable,
+			"The order store is temporarily unavailable")
+	default:
+		h.logger.ErrorContext(r.Context(), "Request failed", "path", r.URL.Path, logging.Err(err))
+		writeProblem(w, r, http.StatusInternalServerError, codeInternalError, "Internal server error")
+	}
+}
@@ -0,0 +1,64 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// NewDatabaseCheck returns a check that pings the database.
+func NewDatabaseCheck(db *sql.DB) Check {
+	return NewCheck(Database, func(ctx context.Context) (Details, error) {
+		if db == nil {
+			return nil, errors.New("no database connection")
+		}
+		return nil, db.PingContext(ctx)
+	})
+}
+
+// CacheSizer is implemented by caches that can report how many orders they hold.
+type CacheSizer interface {
+	Len(ctx context.Context) (int, error)
+}
+
+// NewCacheCheck returns a check that reports the number of cached orders. It fails only if
+// the cache cannot be reached.
+func NewCacheCheck(cache CacheSizer) Check {
+	return NewCheck(Cache, func(ctx context.Context) (Details, error) {
+		entries, err := cache.Len(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return Details{"entries": entries}, nil
+	})
+}
+
+// LagSource reports the number of messages consumed from Kafka but not handled yet.
+type LagSource interface {
+	Lag() int64
+}
+
+// NewConsumerLagCheck returns a check that fails while the consumer lags more than maxLag messages behind.
+func NewConsumerLagCheck(source LagSource, maxLag int64) Check {
+	return NewCheck(ConsumerLag, func(context.Context) (Details, error) {
+		lag := source.Lag()
+		details := Details{"lag": lag, "max_lag": maxLag}
+		if lag > maxLag {
+			return details, fmt.Errorf("consumer lag %d exceeds %d", lag, maxLag)
+		}
+		return details, nil
+	})
+}
+
+// DLQProducerProbe checks whether dead-lettered messages can be produced.
+type DLQProducerProbe interface {
+	CheckDLQProducer(ctx context.Context) error
+}
+
+// NewDLQProducerCheck returns a check of the connectivity of the DLQ producer.
+func NewDLQProducerCheck(probe DLQProducerProbe) Check {
+	return NewCheck(DLQProducer, func(ctx context.Context) (Details, error) {
+		return nil, probe.CheckDLQProducer(ctx)
+	})
+}
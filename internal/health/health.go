@@ -1,49 +1,188 @@
 // Package health provides health checking for application dependencies.
+//
+// Dependencies are checked by a registry of Checks that run concurrently, each with its own
+// timeout. Every dependency is either critical, in which case the service is not ready while
+// it is down, or degraded-only, in which case the service stays ready and keeps serving reads,
+// reporting itself as degraded.
 package health
 
 import (
 	"context"
-	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
-	"github.com/IBM/sarama"
-
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/logging"
 	"wildberries-tech/internal/metrics"
 )
 
-// Status represents the health status of a resource.
+// Component names, as used in Status, in HealthConfig.Critical and as resource metric labels.
+const (
+	Database      = "database"
+	Kafka         = "kafka"
+	ConsumerGroup = "consumer_group"
+	Cache         = "cache"
+	ConsumerLag   = "consumer_lag"
+	DLQProducer   = "dlq_producer"
+)
+
+// State is the state of a single component.
+type State string
+
+// Component states. A component is unknown until it has been checked for the first time.
+const (
+	StateUnknown State = "unknown"
+	StateUp      State = "up"
+	StateDown    State = "down"
+)
+
+// Overall statuses of the service.
+const (
+	// StatusStarting is reported until the first check of the components has finished.
+	StatusStarting = "starting"
+	// StatusOK is reported while all components are up.
+	StatusOK = "ok"
+	// StatusDegraded is reported while only degraded-only components are down.
+	StatusDegraded = "degraded"
+	// StatusDown is reported while a critical component is down.
+	StatusDown = "down"
+)
+
+// errNotGroupMember is the error of the consumer group component while the consumer is not a member.
+var errNotGroupMember = errors.New("not a member of the consumer group")
+
+// Details are check-specific facts about a component, such as the size of a cache.
+type Details map[string]any
+
+// Check checks the health of a single component.
+type Check interface {
+	// Name identifies the component.
+	Name() string
+	// Check returns nil if the component is healthy, along with optional details.
+	// It should return once ctx is done.
+	Check(ctx context.Context) (Details, error)
+}
+
+// NewCheck returns a Check named name that calls fn.
+func NewCheck(name string, fn func(ctx context.Context) (Details, error)) Check {
+	return checkFunc{name: name, fn: fn}
+}
+
+type checkFunc struct {
+	name string
+	fn   func(ctx context.Context) (Details, error)
+}
+
+func (c checkFunc) Name() string { return c.name }
+
+func (c checkFunc) Check(ctx context.Context) (Details, error) { return c.fn(ctx) }
+
+// Component is the health of a single component.
+type Component struct {
+	State    State `json:"state"`
+	Critical bool  `json:"critical"`
+	// LatencyMS is how long the last check took, in milliseconds.
+	LatencyMS float64 `json:"latency_ms"`
+	// LastError is the error of the last failed check, kept after the component recovers.
+	LastError   string     `json:"last_error,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// ConsecutiveFailures counts the checks that failed since the last successful one.
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	Details             Details `json:"details,omitempty"`
+}
+
+// Status represents the health status of the service and its components.
 type Status struct {
-	Database bool `json:"database"`
-	Kafka    bool `json:"kafka"`
+	Status string `json:"status"`
+	// Started is set once the first check of the components has finished.
+	Started bool `json:"started"`
+	// CacheWarm is set once the startup cache warm-up has finished.
+	CacheWarm bool `json:"cache_warm"`
+	// Ready is set while the service can serve requests: it has started, the cache is warm
+	// and no critical component is down.
+	Ready      bool                 `json:"ready"`
+	Components map[string]Component `json:"components"`
 }
 
-// Checker periodically checks the health of dependencies and updates metrics.
+// component is a registered component. Components without a check, such as the consumer
+// group, are reported to the Checker instead.
+type component struct {
+	check   Check
+	timeout time.Duration
+	status  Component
+}
+
+// Checker periodically checks the health of components and updates metrics.
 type Checker struct {
-	db           *sql.DB
-	kafkaBrokers []string
-	metrics      metrics.Metrics
-	interval     time.Duration
+	metrics  metrics.Metrics
+	interval time.Duration
+	timeout  time.Duration
+	critical map[string]bool
+	logger   *slog.Logger
+
+	mu         sync.RWMutex
+	components map[string]*component
+	started    bool
+	cacheWarm  bool
+}
+
+// NewChecker creates a new health checker with no checks registered. Components listed in
+// cfg.Critical are critical, all others are degraded-only. The consumer group component
+// is always present and follows SetGroupMember.
+func NewChecker(m metrics.Metrics, cfg config.HealthConfig, logger *slog.Logger) *Checker {
+	c := &Checker{
+		metrics:    m,
+		interval:   cfg.CheckInterval,
+		timeout:    cfg.CheckTimeout,
+		critical:   make(map[string]bool),
+		logger:     logger,
+		components: make(map[string]*component),
+	}
+	for _, name := range cfg.Critical {
+		c.critical[name] = true
+	}
+	c.components[ConsumerGroup] = c.newComponent(ConsumerGroup, nil, 0)
+	return c
+}
 
-	mu     sync.RWMutex
-	status Status
+func (c *Checker) newComponent(name string, check Check, timeout time.Duration) *component {
+	return &component{
+		check:   check,
+		timeout: timeout,
+		status:  Component{State: StateUnknown, Critical: c.critical[name]},
+	}
 }
 
-// NewChecker creates a new health checker.
-func NewChecker(db *sql.DB, kafkaBrokers []string, m metrics.Metrics, interval time.Duration) *Checker {
-	return &Checker{
-		db:           db,
-		kafkaBrokers: kafkaBrokers,
-		metrics:      m,
-		interval:     interval,
-		status:       Status{Database: true, Kafka: true},
+// Register adds a check, run every check interval with the given timeout or, if timeout is
+// zero, the configured one. A check replaces any check registered under the same name.
+// Checks have to be registered before Start.
+func (c *Checker) Register(check Check, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = c.timeout
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.components[check.Name()] = c.newComponent(check.Name(), check, timeout)
 }
 
 // Start begins periodic health checking. Should be called in a goroutine.
 func (c *Checker) Start(ctx context.Context) {
-	c.check()
+	c.mu.RLock()
+	for name := range c.critical {
+		if _, ok := c.components[name]; !ok {
+			c.logger.Warn("Critical component has no health check", "component", name)
+		}
+	}
+	c.mu.RUnlock()
+
+	c.checkAll(ctx)
+
+	c.mu.Lock()
+	c.started = true
+	c.mu.Unlock()
 
 	ticker := time.NewTicker(c.interval)
 	defer ticker.Stop()
@@ -51,85 +190,172 @@ func (c *Checker) Start(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Health checker stopping...")
+			c.logger.Info("Health checker stopping")
 			return
 		case <-ticker.C:
-			c.check()
+			c.checkAll(ctx)
 		}
 	}
 }
 
-func (c *Checker) check() {
-	dbUp := c.pingDB()
-	kafkaUp := c.pingKafka()
+// checkAll runs all checks concurrently and waits for them to finish or time out.
+func (c *Checker) checkAll(ctx context.Context) {
+	c.mu.RLock()
+	var wg sync.WaitGroup
+	for name, comp := range c.components {
+		if comp.check == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, check Check, timeout time.Duration) {
+			defer wg.Done()
+			c.run(ctx, name, check, timeout)
+		}(name, comp.check, comp.timeout)
+	}
+	c.mu.RUnlock()
+	wg.Wait()
+}
 
-	c.mu.Lock()
-	c.status.Database = dbUp
-	c.status.Kafka = kafkaUp
-	c.mu.Unlock()
+// run runs a single check and records its result. A check that does not return within its
+// timeout is recorded as failed; it is left to return in the background.
+func (c *Checker) run(ctx context.Context, name string, check Check, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	if dbUp {
-		c.metrics.SetResourceUp("database", 1)
-	} else {
-		c.metrics.SetResourceUp("database", 0)
+	type result struct {
+		details Details
+		err     error
 	}
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		details, err := check.Check(ctx)
+		done <- result{details, err}
+	}()
 
-	if kafkaUp {
-		c.metrics.SetResourceUp("kafka", 1)
-	} else {
-		c.metrics.SetResourceUp("kafka", 0)
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		r.err = fmt.Errorf("check did not finish within %s: %w", timeout, ctx.Err())
 	}
+	c.record(name, time.Since(start), r.details, r.err)
 }
 
-func (c *Checker) pingDB() bool {
-	if c.db == nil {
-		return false
-	}
+// record stores the result of checking a component, exports it as a metric and logs changes of state.
+func (c *Checker) record(name string, latency time.Duration, details Details, err error) {
+	now := time.Now()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+	c.mu.Lock()
+	comp, ok := c.components[name]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	previous := comp.status.State
+	status := &comp.status
+	status.LatencyMS = float64(latency.Microseconds()) / 1000
+	status.Details = details
+	if err == nil {
+		status.State = StateUp
+		status.LastSuccess = &now
+		status.ConsecutiveFailures = 0
+	} else {
+		status.State = StateDown
+		status.LastError = err.Error()
+		status.ConsecutiveFailures++
+	}
+	c.mu.Unlock()
 
-	if err := c.db.PingContext(ctx); err != nil {
-		log.Printf("Database ping failed: %v", err)
-		return false
+	if err == nil {
+		c.metrics.SetResourceUp(name, 1)
+		if previous == StateDown {
+			c.logger.Info("Health check recovered", "component", name)
+		}
+	} else {
+		c.metrics.SetResourceUp(name, 0)
+		if previous != StateDown {
+			c.logger.Warn("Health check failed", "component", name, logging.Err(err))
+		}
 	}
-	return true
 }
 
-func (c *Checker) pingKafka() bool {
-	if len(c.kafkaBrokers) == 0 {
-		return false
-	}
+// Status returns the current health status.
+func (c *Checker) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	config := sarama.NewConfig()
-	config.Net.DialTimeout = 2 * time.Second
+	status := Status{
+		Status:     c.statusLocked(),
+		Started:    c.started,
+		CacheWarm:  c.cacheWarm,
+		Ready:      c.readyLocked(),
+		Components: make(map[string]Component, len(c.components)),
+	}
+	for name, comp := range c.components {
+		status.Components[name] = comp.status
+	}
+	return status
+}
 
-	client, err := sarama.NewClient(c.kafkaBrokers, config)
-	if err != nil {
-		log.Printf("Kafka ping failed: %v", err)
-		return false
+// statusLocked returns the overall status. A component that has not been seen up yet counts as down.
+func (c *Checker) statusLocked() string {
+	if !c.started {
+		return StatusStarting
 	}
-	defer func() {
-		if err := client.Close(); err != nil {
-			log.Printf("Error closing Kafka client: %v", err)
+	status := StatusOK
+	for _, comp := range c.components {
+		if comp.status.State == StateUp {
+			continue
 		}
-	}()
+		if comp.status.Critical {
+			return StatusDown
+		}
+		status = StatusDegraded
+	}
+	return status
+}
 
-	// Requesting brokers proves connectivity
-	_ = client.Brokers()
-	return true
+func (c *Checker) readyLocked() bool {
+	status := c.statusLocked()
+	return c.cacheWarm && (status == StatusOK || status == StatusDegraded)
 }
 
-// Status returns the current health status.
-func (c *Checker) Status() Status {
+// DatabaseUp reports whether the database has not been found down by the last check.
+func (c *Checker) DatabaseUp() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	comp, ok := c.components[Database]
+	return !ok || comp.status.State != StateDown
+}
+
+// SetCacheWarm records that the startup cache warm-up has finished.
+func (c *Checker) SetCacheWarm() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cacheWarm = true
+}
+
+// SetGroupMember records whether the Kafka consumer is a member of its consumer group.
+func (c *Checker) SetGroupMember(joined bool) {
+	var err error
+	if !joined {
+		err = errNotGroupMember
+	}
+	c.record(ConsumerGroup, 0, nil, err)
+}
+
+// IsStarted returns true once the first check of the components has finished.
+func (c *Checker) IsStarted() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.status
+	return c.started
 }
 
-// IsHealthy returns true if all dependencies are up.
-func (c *Checker) IsHealthy() bool {
+// IsReady returns true if the service has started, the cache has been warmed up and no
+// critical component is down.
+func (c *Checker) IsReady() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.status.Database && c.status.Kafka
+	return c.readyLocked()
 }
@@ -0,0 +1,193 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/metrics"
+)
+
+func newTestChecker(critical ...string) *Checker {
+	return NewChecker(metrics.Nop{}, config.HealthConfig{CheckTimeout: time.Second, Critical: critical}, logging.Nop())
+}
+
+// switchableCheck is a Check whose result can be changed.
+type switchableCheck struct {
+	name string
+	err  error
+}
+
+func (c *switchableCheck) Name() string { return c.name }
+
+func (c *switchableCheck) Check(context.Context) (Details, error) { return nil, c.err }
+
+// serve returns the status code and decoded body of a health endpoint.
+func serve(t *testing.T, handler http.HandlerFunc) (int, Status) {
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	var status Status
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&status))
+	return rr.Code, status
+}
+
+// start runs the first round of checks the way Start does.
+func start(c *Checker) {
+	c.checkAll(context.Background())
+	c.started = true
+}
+
+func TestChecker_Starting(t *testing.T) {
+	c := newTestChecker(Database)
+	c.Register(&switchableCheck{name: Database}, 0)
+
+	assert.True(t, c.DatabaseUp(), "an unchecked database is not reported down")
+	assert.Equal(t, StateUnknown, c.Status().Components[Database].State)
+
+	code, status := serve(t, c.Startupz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusStarting, status.Status)
+
+	code, _ = serve(t, c.Readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	rr := httptest.NewRecorder()
+	c.Livez(rr, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, rr.Code, "liveness does not depend on components")
+}
+
+func TestChecker_DegradedStaysReady(t *testing.T) {
+	c := newTestChecker(Database)
+	kafka := &switchableCheck{name: Kafka, err: errors.New("no brokers")}
+	c.Register(&switchableCheck{name: Database}, 0)
+	c.Register(kafka, 0)
+	c.SetGroupMember(true)
+	c.SetCacheWarm()
+	start(c)
+
+	code, status := serve(t, c.Readyz)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusDegraded, status.Status)
+	assert.True(t, status.Ready)
+	assert.Equal(t, StateDown, status.Components[Kafka].State)
+	assert.False(t, status.Components[Kafka].Critical)
+	assert.Equal(t, StateUp, status.Components[Database].State)
+	assert.True(t, status.Components[Database].Critical)
+
+	kafka.err = nil
+	c.checkAll(context.Background())
+	assert.Equal(t, StatusOK, c.Status().Status)
+}
+
+func TestChecker_CriticalDownIsNotReady(t *testing.T) {
+	c := newTestChecker(Database, ConsumerGroup)
+	database := &switchableCheck{name: Database}
+	c.Register(database, 0)
+	c.SetCacheWarm()
+	start(c)
+
+	code, status := serve(t, c.Readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code, "the consumer has not joined its group yet")
+	assert.Equal(t, StatusDown, status.Status)
+
+	c.SetGroupMember(true)
+	assert.True(t, c.IsReady())
+
+	database.err = errors.New("connection refused")
+	c.checkAll(context.Background())
+	assert.False(t, c.IsReady())
+	assert.False(t, c.DatabaseUp())
+}
+
+func TestChecker_WaitsForCacheWarmUp(t *testing.T) {
+	c := newTestChecker()
+	c.SetGroupMember(true)
+	start(c)
+
+	code, _ := serve(t, c.Startupz)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, c.IsReady())
+
+	c.SetCacheWarm()
+	assert.True(t, c.IsReady())
+}
+
+func TestChecker_RecordsFailureDetails(t *testing.T) {
+	c := newTestChecker()
+	check := &switchableCheck{name: Database}
+	c.Register(check, 0)
+
+	c.checkAll(context.Background())
+	firstSuccess := c.Status().Components[Database].LastSuccess
+	require.NotNil(t, firstSuccess)
+
+	check.err = errors.New("connection refused")
+	c.checkAll(context.Background())
+	c.checkAll(context.Background())
+
+	component := c.Status().Components[Database]
+	assert.Equal(t, StateDown, component.State)
+	assert.Equal(t, "connection refused", component.LastError)
+	assert.Equal(t, 2, component.ConsecutiveFailures)
+	assert.Equal(t, firstSuccess, component.LastSuccess)
+
+	check.err = nil
+	c.checkAll(context.Background())
+	component = c.Status().Components[Database]
+	assert.Equal(t, StateUp, component.State)
+	assert.Zero(t, component.ConsecutiveFailures)
+	assert.Equal(t, "connection refused", component.LastError, "the last error is kept after recovery")
+}
+
+func TestChecker_RunsChecksConcurrentlyWithTimeouts(t *testing.T) {
+	c := newTestChecker()
+	slow := func(name string) Check {
+		return NewCheck(name, func(ctx context.Context) (Details, error) {
+			select {
+			case <-time.After(100 * time.Millisecond):
+				return Details{"slept": true}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+	}
+	c.Register(slow(Database), 0)
+	c.Register(slow(Kafka), 0)
+	c.Register(NewCheck(DLQProducer, func(context.Context) (Details, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	}), 20*time.Millisecond)
+
+	began := time.Now()
+	c.checkAll(context.Background())
+	assert.Less(t, time.Since(began), 500*time.Millisecond, "checks run concurrently and stuck checks time out")
+
+	status := c.Status()
+	assert.Equal(t, StateUp, status.Components[Database].State)
+	assert.Equal(t, Details{"slept": true}, status.Components[Database].Details)
+	assert.GreaterOrEqual(t, status.Components[Kafka].LatencyMS, 100.0)
+	assert.Equal(t, StateDown, status.Components[DLQProducer].State)
+	assert.Contains(t, status.Components[DLQProducer].LastError, "did not finish within 20ms")
+}
+
+func TestConsumerLagCheck(t *testing.T) {
+	details, err := NewConsumerLagCheck(lagFunc(5), 10).Check(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Details{"lag": int64(5), "max_lag": int64(10)}, details)
+
+	_, err = NewConsumerLagCheck(lagFunc(11), 10).Check(context.Background())
+	assert.EqualError(t, err, "consumer lag 11 exceeds 10")
+}
+
+type lagFunc int64
+
+func (l lagFunc) Lag() int64 { return int64(l) }
@@ -0,0 +1,42 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"wildberries-tech/internal/logging"
+)
+
+// Livez responds 200 as long as the process can serve HTTP. It does not depend on any
+// dependency, so that an outage of one does not get the service restarted.
+func (c *Checker) Livez(w http.ResponseWriter, _ *http.Request) {
+	c.writeJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+}
+
+// Startupz responds 200 once the first check of the dependencies has finished, and 503 before.
+func (c *Checker) Startupz(w http.ResponseWriter, _ *http.Request) {
+	c.writeStatus(w, c.IsStarted())
+}
+
+// Readyz responds 200 while the service is ready, including while it is degraded, and 503
+// while it is not. The body is the full Status.
+func (c *Checker) Readyz(w http.ResponseWriter, _ *http.Request) {
+	c.writeStatus(w, c.IsReady())
+}
+
+func (c *Checker) writeStatus(w http.ResponseWriter, ok bool) {
+	code := http.StatusOK
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+	c.writeJSON(w, code, c.Status())
+}
+
+func (c *Checker) writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		c.logger.Warn("Error encoding health status", logging.Err(err))
+	}
+}
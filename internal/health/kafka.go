@@ -0,0 +1,204 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"wildberries-tech/internal/config"
+)
+
+// Reasons reported under "reason" in the details of a failed Kafka check.
+const (
+	ReasonUnreachable    = "unreachable"
+	ReasonTopicMissing   = "topic_missing"
+	ReasonNoLeader       = "partition_without_leader"
+	ReasonGroupNotStable = "group_not_stable"
+)
+
+// groupStateStable is the state of a consumer group whose members all have their partitions.
+const groupStateStable = "Stable"
+
+// kafkaCheckNetTimeout bounds connecting to a broker and every request sent to it.
+const kafkaCheckNetTimeout = 2 * time.Second
+
+// KafkaCheck checks that the Kafka cluster is reachable, that the order and DLQ topics exist
+// with a leader for every partition, and that the consumer group is stable. It keeps one
+// client for its whole lifetime, connecting again only while it has no open client.
+type KafkaCheck struct {
+	brokers []string
+	topics  []string
+	groupID string
+	config  *sarama.Config
+
+	// lock is held only while the client is opened or closed. It is a channel rather than
+	// a mutex so that a check waiting for another one to connect gives up once its context is done.
+	lock   chan struct{}
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+// NewKafkaCheck creates a Kafka check for the topics and the consumer group of cfg.
+// The connection is opened by the first check.
+func NewKafkaCheck(cfg config.KafkaConfig) *KafkaCheck {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Net.DialTimeout = kafkaCheckNetTimeout
+	saramaConfig.Net.ReadTimeout = kafkaCheckNetTimeout
+	saramaConfig.Net.WriteTimeout = kafkaCheckNetTimeout
+	// A failed check is repeated at the next interval anyway.
+	saramaConfig.Metadata.Retry.Max = 0
+
+	return &KafkaCheck{
+		brokers: cfg.Brokers,
+		topics:  []string{cfg.Topic, cfg.DLQTopic},
+		groupID: cfg.GroupID,
+		config:  saramaConfig,
+		lock:    make(chan struct{}, 1),
+	}
+}
+
+// Name implements Check.
+func (k *KafkaCheck) Name() string { return Kafka }
+
+// Check implements Check. Failures carry one of the Reason constants in their details.
+// The shared client is used concurrently, and ctx is checked before every request to the cluster.
+func (k *KafkaCheck) Check(ctx context.Context) (Details, error) {
+	client, admin, err := k.connect(ctx)
+	if err != nil {
+		return Details{"reason": ReasonUnreachable}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Details{"reason": ReasonUnreachable}, err
+	}
+	if err := client.RefreshMetadata(); err != nil {
+		return Details{"reason": ReasonUnreachable}, fmt.Errorf("error refreshing metadata: %w", err)
+	}
+	details := Details{"brokers": len(client.Brokers())}
+
+	existing, err := client.Topics()
+	if err != nil {
+		return failed(details, ReasonUnreachable), err
+	}
+	var missing []string
+	for _, topic := range k.topics {
+		if !slices.Contains(existing, topic) {
+			missing = append(missing, topic)
+		}
+	}
+	if len(missing) > 0 {
+		return failed(details, ReasonTopicMissing), fmt.Errorf("topics %v do not exist", missing)
+	}
+
+	partitions := make(map[string]int, len(k.topics))
+	for _, topic := range k.topics {
+		if err := ctx.Err(); err != nil {
+			return failed(details, ReasonUnreachable), err
+		}
+		ids, err := client.Partitions(topic)
+		if err != nil {
+			return failed(details, ReasonUnreachable), fmt.Errorf("error listing partitions of %s: %w", topic, err)
+		}
+		partitions[topic] = len(ids)
+		if leaderless := leaderless(client, topic, ids); len(leaderless) > 0 {
+			details["partitions"] = partitions
+			return failed(details, ReasonNoLeader),
+				fmt.Errorf("partitions %v of %s have no leader", leaderless, topic)
+		}
+	}
+	details["partitions"] = partitions
+
+	if err := ctx.Err(); err != nil {
+		return failed(details, ReasonUnreachable), err
+	}
+	state, err := k.groupState(admin)
+	if err != nil {
+		return failed(details, ReasonUnreachable), err
+	}
+	details["group_state"] = state
+	if state != groupStateStable {
+		return failed(details, ReasonGroupNotStable),
+			fmt.Errorf("consumer group %s is %s", k.groupID, state)
+	}
+	return details, nil
+}
+
+// connect returns the client and the cluster admin, creating them unless they exist already.
+func (k *KafkaCheck) connect(ctx context.Context) (sarama.Client, sarama.ClusterAdmin, error) {
+	select {
+	case k.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	defer func() { <-k.lock }()
+
+	if k.client != nil && !k.client.Closed() {
+		return k.client, k.admin, nil
+	}
+	if len(k.brokers) == 0 {
+		return nil, nil, errors.New("no Kafka brokers configured")
+	}
+
+	client, err := sarama.NewClient(k.brokers, k.config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating Kafka client: %w", err)
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, nil, fmt.Errorf("error creating cluster admin: %w", err)
+	}
+	k.client, k.admin = client, admin
+	return client, admin, nil
+}
+
+// leaderless returns the partitions of topic that have no available leader.
+func leaderless(client sarama.Client, topic string, partitions []int32) []int32 {
+	var leaderless []int32
+	for _, partition := range partitions {
+		if _, err := client.Leader(topic, partition); err != nil {
+			leaderless = append(leaderless, partition)
+		}
+	}
+	return leaderless
+}
+
+// groupState returns the state of the consumer group, such as Stable or PreparingRebalance.
+func (k *KafkaCheck) groupState(admin sarama.ClusterAdmin) (string, error) {
+	groups, err := admin.DescribeConsumerGroups([]string{k.groupID})
+	if err != nil {
+		return "", fmt.Errorf("error describing consumer group %s: %w", k.groupID, err)
+	}
+	for _, group := range groups {
+		if group.GroupId != k.groupID {
+			continue
+		}
+		if group.Err != sarama.ErrNoError {
+			return "", fmt.Errorf("error describing consumer group %s: %w", k.groupID, group.Err)
+		}
+		return group.State, nil
+	}
+	return "", fmt.Errorf("consumer group %s was not described", k.groupID)
+}
+
+// Close closes the client of the check. Checks still running with it fail.
+func (k *KafkaCheck) Close() error {
+	k.lock <- struct{}{}
+	defer func() { <-k.lock }()
+	if k.admin == nil {
+		return nil
+	}
+	// Closing the admin also closes the client it was created from.
+	err := k.admin.Close()
+	k.client, k.admin = nil, nil
+	return err
+}
+
+// failed returns details extended with the reason of a failure.
+func failed(details Details, reason string) Details {
+	details["reason"] = reason
+	return details
+}
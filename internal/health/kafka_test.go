@@ -0,0 +1,120 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"wildberries-tech/internal/config"
+)
+
+const (
+	testTopic = "orders"
+	testDLQ   = "orders-dlq"
+	testGroup = "orders-service"
+)
+
+// newTestCluster starts a mock broker leading partition 0 of every given topic.
+func newTestCluster(t *testing.T, groupState string, topics ...string) (*sarama.MockBroker, *sarama.MockMetadataResponse) {
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(broker.Addr(), broker.BrokerID()).
+		SetController(broker.BrokerID())
+	for _, topic := range topics {
+		metadata.SetLeader(topic, 0, broker.BrokerID())
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest":    metadata,
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, testGroup, broker),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription(testGroup, &sarama.GroupDescription{GroupId: testGroup, State: groupState}),
+	})
+	return broker, metadata
+}
+
+func newTestKafkaCheck(t *testing.T, broker *sarama.MockBroker) *KafkaCheck {
+	check := NewKafkaCheck(config.KafkaConfig{
+		Brokers: []string{broker.Addr()}, Topic: testTopic, DLQTopic: testDLQ, GroupID: testGroup,
+	})
+	t.Cleanup(func() { _ = check.Close() })
+	return check
+}
+
+func TestKafkaCheck_Healthy(t *testing.T) {
+	broker, _ := newTestCluster(t, "Stable", testTopic, testDLQ)
+	check := newTestKafkaCheck(t, broker)
+
+	details, err := check.Check(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{testTopic: 1, testDLQ: 1}, details["partitions"])
+	assert.Equal(t, "Stable", details["group_state"])
+
+	client := check.client
+	_, err = check.Check(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, client, check.client, "the client is reused between checks")
+}
+
+func TestKafkaCheck_FailureReasons(t *testing.T) {
+	t.Run("topic missing", func(t *testing.T) {
+		broker, _ := newTestCluster(t, "Stable", testTopic)
+		details, err := newTestKafkaCheck(t, broker).Check(context.Background())
+		assert.EqualError(t, err, "topics [orders-dlq] do not exist")
+		assert.Equal(t, ReasonTopicMissing, details["reason"])
+	})
+
+	t.Run("partition without leader", func(t *testing.T) {
+		broker, metadata := newTestCluster(t, "Stable", testTopic, testDLQ)
+		metadata.SetLeader(testTopic, 1, 2) // broker 2 is not part of the cluster
+		details, err := newTestKafkaCheck(t, broker).Check(context.Background())
+		assert.EqualError(t, err, "partitions [1] of orders have no leader")
+		assert.Equal(t, ReasonNoLeader, details["reason"])
+	})
+
+	t.Run("group rebalancing", func(t *testing.T) {
+		broker, _ := newTestCluster(t, "PreparingRebalance", testTopic, testDLQ)
+		details, err := newTestKafkaCheck(t, broker).Check(context.Background())
+		assert.EqualError(t, err, "consumer group orders-service is PreparingRebalance")
+		assert.Equal(t, ReasonGroupNotStable, details["reason"])
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		broker := sarama.NewMockBroker(t, 1)
+		broker.Close()
+		details, err := newTestKafkaCheck(t, broker).Check(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, ReasonUnreachable, details["reason"])
+	})
+}
+
+func TestKafkaCheck_HonorsContext(t *testing.T) {
+	broker, _ := newTestCluster(t, "Stable", testTopic, testDLQ)
+	check := newTestKafkaCheck(t, broker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	details, err := check.Check(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, ReasonUnreachable, details["reason"])
+
+	// Another check connecting to a slow broker holds the lock.
+	check.lock <- struct{}{}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	_, err = check.Check(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(began), time.Second, "a check does not wait for another one to connect")
+	<-check.lock
+
+	_, err = check.Check(context.Background())
+	require.NoError(t, err)
+}
@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errBackpressure is returned by processMessage when a save failed while the database is
+// unavailable. The message is held and retried after fetching resumes instead of being dead-lettered.
+var errBackpressure = errors.New("database unavailable, holding message")
+
+// DatabaseProbe reports whether the database is reachable. health.Checker implements it.
+type DatabaseProbe interface {
+	DatabaseUp() bool
+}
+
+// pauser is the part of sarama.ConsumerGroup used to stop and restart fetching.
+type pauser interface {
+	PauseAll()
+	ResumeAll()
+}
+
+// backpressure pauses the consumer group while the database is down or saves keep failing,
+// turning a database outage into consumer lag instead of dead-lettered orders.
+type backpressure struct {
+	probe     DatabaseProbe
+	threshold int
+	interval  time.Duration
+	logger    *slog.Logger
+
+	mu       sync.Mutex
+	group    pauser
+	failures int
+	paused   bool
+	resumed  chan struct{}
+}
+
+func newBackpressure(probe DatabaseProbe, threshold int, interval time.Duration, logger *slog.Logger) *backpressure {
+	return &backpressure{
+		probe:     probe,
+		threshold: max(threshold, 1),
+		interval:  interval,
+		logger:    logger,
+	}
+}
+
+// run re-evaluates the pause state every interval until ctx is cancelled.
+func (b *backpressure) run(ctx context.Context, group pauser) {
+	b.mu.Lock()
+	b.group = group
+	b.mu.Unlock()
+
+	if b.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.check()
+		}
+	}
+}
+
+// check pauses fetching while the database is reported down. Once it is reported up again,
+// fetching resumes and held messages are retried. The failure count is kept until a save
+// succeeds, so a single further failure pauses again instead of dead-lettering messages.
+func (b *backpressure) check() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case !b.databaseUp():
+		// PauseAll only affects partitions claimed at the time of the call,
+		// so it is repeated to cover partitions assigned by a rebalance.
+		b.pauseLocked("database is down")
+	case b.paused:
+		b.resumeLocked()
+	}
+}
+
+// engaged reports whether failed saves should be held rather than dead-lettered.
+func (b *backpressure) engaged() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.paused || b.failures >= b.threshold || !b.databaseUp()
+}
+
+// recordSuccess resets the consecutive failure count after a successful save.
+func (b *backpressure) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// recordFailure counts a save that failed with a transient error after all retries and
+// pauses fetching once the threshold is reached or the database is reported down.
+func (b *backpressure) recordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures >= b.threshold || !b.databaseUp() {
+		b.pauseLocked("saves are failing")
+	}
+}
+
+// wait blocks while fetching is paused. It returns ctx.Err() if ctx is cancelled first.
+func (b *backpressure) wait(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.paused {
+		b.mu.Unlock()
+		return nil
+	}
+	resumed := b.resumed
+	b.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
+
+func (b *backpressure) databaseUp() bool {
+	return b.probe == nil || b.probe.DatabaseUp()
+}
+
+func (b *backpressure) pauseLocked(reason string) {
+	if b.group != nil {
+		b.group.PauseAll()
+	}
+	if b.paused {
+		return
+	}
+
+	b.paused = true
+	b.resumed = make(chan struct{})
+	b.logger.Warn("Pausing Kafka consumption", "reason", reason)
+}
+
+func (b *backpressure) resumeLocked() {
+	if b.group != nil {
+		b.group.ResumeAll()
+	}
+
+	b.paused = false
+	close(b.resumed)
+	b.logger.Info("Resuming Kafka consumption")
+}
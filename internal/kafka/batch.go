@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/models"
+)
+
+// saveBatch decodes and validates msgs and saves the valid orders among them in a single transaction.
+// It reports which messages are done with. The others, i.e. invalid messages and every message of a batch
+// that could not be saved, have to go through processMessage one by one, which retries, resolves
+// duplicates or dead-letters each of them. The batch is traced in a span linked to the trace of every message.
+func (c *Consumer) saveBatch(ctx context.Context, msgs []*sarama.ConsumerMessage) []bool {
+	saved := make([]bool, len(msgs))
+
+	links := make([]trace.Link, 0, len(msgs))
+	for _, msg := range msgs {
+		if sc := trace.SpanContextFromContext(ExtractTraceContext(ctx, msg)); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "order.save_batch",
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithLinks(links...),
+		trace.WithAttributes(semconv.MessagingSystem("kafka"), semconv.MessagingBatchMessageCount(len(msgs))))
+	defer span.End()
+
+	orders := make([]models.Order, 0, len(msgs))
+	indexes := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		var order models.Order
+		if err := json.Unmarshal(msg.Value, &order); err != nil {
+			continue
+		}
+		if err := order.Validate(); err != nil {
+			continue
+		}
+		orders = append(orders, order)
+		indexes = append(indexes, i)
+	}
+	if len(orders) < 2 {
+		return saved
+	}
+
+	if err := c.repo.SaveOrders(ctx, orders); err != nil {
+		span.RecordError(err)
+		c.logger.WarnContext(ctx, "Error saving batch, saving orders one by one",
+			"orders", len(orders), logging.Err(err))
+		return saved
+	}
+
+	c.pressure.recordSuccess()
+	for k, order := range orders {
+		c.cache.Set(ctx, order.OrderUID, order)
+		c.metrics.IncMessagesTotal("success")
+		saved[indexes[k]] = true
+	}
+
+	c.logger.InfoContext(ctx, "Batch processed successfully", "orders", len(orders))
+	return saved
+}
@@ -4,140 +4,389 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
 
 	"wildberries-tech/internal/cache"
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/logging"
 	"wildberries-tech/internal/metrics"
 	"wildberries-tech/internal/models"
 	"wildberries-tech/internal/repository"
 )
 
-// Consumer consumes orders from Kafka and saves them to the repository.
+const (
+	// rejoinDelay is how long the consumer waits before rejoining the group after a failed session.
+	rejoinDelay = 2 * time.Second
+	// redeliveryDelay is how long a message that could be neither saved nor dead-lettered
+	// waits before it is processed again.
+	redeliveryDelay = time.Second
+)
+
+// Consumer consumes orders from Kafka as a member of a consumer group and saves them to the repository.
 type Consumer struct {
 	repo        repository.OrderRepository
 	cache       cache.OrderCache
 	metrics     metrics.Metrics
-	brokers     []string
-	topic       string
-	dlqTopic    string
+	cfg         config.KafkaConfig
 	dlqProducer sarama.SyncProducer
+	pressure    *backpressure
+	membership  MembershipObserver
+	lag         *lagTracker
+	logger      *slog.Logger
+
+	// dlqClient is the client of dlqProducer, kept to check its connectivity while the consumer runs.
+	// client is the client of the consumer group, kept to look up high-water marks for Lag.
+	mu        sync.Mutex
+	dlqClient sarama.Client
+	client    sarama.Client
+}
+
+// MembershipObserver is told whether the consumer is a member of its consumer group.
+// health.Checker implements it.
+type MembershipObserver interface {
+	SetGroupMember(joined bool)
 }
 
-// NewConsumer creates a new Consumer instance.
+// NewConsumer creates a new Consumer instance. probe is consulted to pause consumption
+// while the database is down; it may be nil, in which case only failing saves pause it.
+// If probe also implements MembershipObserver, it is told when the consumer joins and
+// leaves the group.
 func NewConsumer(repo repository.OrderRepository, cache cache.OrderCache, m metrics.Metrics,
-	brokers []string, topic, dlqTopic string) *Consumer {
+	cfg config.KafkaConfig, probe DatabaseProbe, logger *slog.Logger) *Consumer {
+	membership, _ := probe.(MembershipObserver)
 	return &Consumer{
-		repo:     repo,
-		cache:    cache,
-		metrics:  m,
-		brokers:  brokers,
-		topic:    topic,
-		dlqTopic: dlqTopic,
+		repo:       repo,
+		cache:      cache,
+		metrics:    m,
+		cfg:        cfg,
+		pressure:   newBackpressure(probe, cfg.PauseFailureThreshold, cfg.PauseCheckInterval, logger),
+		membership: membership,
+		lag:        newLagTracker(),
+		logger:     logger,
 	}
 }
 
-// Start begins consuming messages from Kafka.
+// Lag returns the number of messages of the partitions claimed by this member that have been
+// produced but not handled yet. It is zero while the consumer is not a group member.
+// High-water marks are refreshed from the brokers first, so lag is also reported while paused.
+func (c *Consumer) Lag() int64 {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	if client != nil && !client.Closed() {
+		if err := c.lag.refresh(client, c.cfg.Topic); err != nil {
+			c.logger.Debug("Using last known high-water marks for consumer lag", logging.Err(err))
+		}
+	}
+	return c.lag.total()
+}
+
+// CheckDLQProducer returns nil if the DLQ producer is running and can reach a leader of
+// a partition of the DLQ topic.
+func (c *Consumer) CheckDLQProducer(context.Context) error {
+	c.mu.Lock()
+	client := c.dlqClient
+	c.mu.Unlock()
+	if client == nil || client.Closed() {
+		return errors.New("DLQ producer is not running")
+	}
+
+	if err := client.RefreshMetadata(c.cfg.DLQTopic); err != nil {
+		return fmt.Errorf("error refreshing metadata of %s: %w", c.cfg.DLQTopic, err)
+	}
+	partitions, err := client.WritablePartitions(c.cfg.DLQTopic)
+	if err != nil {
+		return fmt.Errorf("error listing partitions of %s: %w", c.cfg.DLQTopic, err)
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("%s has no partition with a leader", c.cfg.DLQTopic)
+	}
+	return nil
+}
+
+func (c *Consumer) setDLQClient(client sarama.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dlqClient = client
+}
+
+func (c *Consumer) setClient(client sarama.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client = client
+}
+
+// setGroupMember reports to the membership observer, if any, whether the consumer is a group member.
+func (c *Consumer) setGroupMember(joined bool) {
+	if c.membership != nil {
+		c.membership.SetGroupMember(joined)
+	}
+}
+
+// Start joins the consumer group and consumes messages from all partitions assigned to this member.
+// It blocks until ctx is cancelled.
 func (c *Consumer) Start(ctx context.Context) error {
-	config := sarama.NewConfig()
-	config.Consumer.Return.Errors = true
+	config, err := c.saramaConfig()
+	if err != nil {
+		return err
+	}
 
 	// Initialize DLQ Producer
 	dlqConfig := sarama.NewConfig()
 	dlqConfig.Producer.Return.Successes = true
-	producer, err := sarama.NewSyncProducer(c.brokers, dlqConfig)
+	dlqClient, err := sarama.NewClient(c.cfg.Brokers, dlqConfig)
 	if err != nil {
+		return fmt.Errorf("error creating DLQ client: %w", err)
+	}
+	producer, err := sarama.NewSyncProducerFromClient(dlqClient)
+	if err != nil {
+		_ = dlqClient.Close()
 		return fmt.Errorf("error creating DLQ producer: %w", err)
 	}
 	c.dlqProducer = producer
+	c.setDLQClient(dlqClient)
 	defer func() {
+		c.setDLQClient(nil)
 		if err := c.dlqProducer.Close(); err != nil {
-			log.Println("Error closing DLQ producer:", err)
+			c.logger.Warn("Error closing DLQ producer", logging.Err(err))
+		}
+		if err := dlqClient.Close(); err != nil {
+			c.logger.Warn("Error closing DLQ client", logging.Err(err))
 		}
 	}()
 
-	consumer, err := sarama.NewConsumer(c.brokers, config)
+	client, err := sarama.NewClient(c.cfg.Brokers, config)
 	if err != nil {
-		return fmt.Errorf("error creating consumer: %w", err)
+		return fmt.Errorf("error creating kafka client: %w", err)
 	}
+	c.setClient(client)
 	defer func() {
-		if err := consumer.Close(); err != nil {
-			log.Println("Error closing consumer:", err)
+		c.setClient(nil)
+		if err := client.Close(); err != nil && !errors.Is(err, sarama.ErrClosedClient) {
+			c.logger.Warn("Error closing Kafka client", logging.Err(err))
 		}
 	}()
 
-	partitionConsumer, err := consumer.ConsumePartition(c.topic, 0, sarama.OffsetNewest)
+	group, err := sarama.NewConsumerGroupFromClient(c.cfg.GroupID, client)
 	if err != nil {
-		return fmt.Errorf("error creating partition consumer: %w", err)
+		return fmt.Errorf("error creating consumer group: %w", err)
 	}
 	defer func() {
-		if err := partitionConsumer.Close(); err != nil {
-			log.Println("Error closing partition consumer:", err)
+		if err := group.Close(); err != nil {
+			c.logger.Warn("Error closing consumer group", logging.Err(err))
 		}
 	}()
 
-	log.Println("Kafka consumer started...")
+	go func() {
+		for err := range group.Errors() {
+			c.logger.Error("Consumer group error", logging.Err(err))
+		}
+	}()
 
+	c.logger.Info("Kafka consumer started", "group", c.cfg.GroupID, logging.KeyTopic, c.cfg.Topic)
+	defer c.setGroupMember(false)
+
+	go c.pressure.run(ctx, group)
+
+	handler := &groupHandler{consumer: c, client: client}
 	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Stopping consumer...")
+		// Consume blocks for the lifetime of a single group session and has to be
+		// called again after every rebalance to receive the new partition assignment.
+		if err := group.Consume(ctx, []string{c.cfg.Topic}, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
+			}
+			// Membership is only reported lost here, not at the end of every session, so that
+			// a rebalance, which ends one session just before the next one starts, does not count.
+			c.setGroupMember(false)
+			c.logger.Error("Consumer group session error", logging.Err(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(rejoinDelay):
+			}
+		}
+
+		if ctx.Err() != nil {
+			c.logger.Info("Stopping consumer")
 			return nil
-		case msg := <-partitionConsumer.Messages():
-			c.processMessage(msg.Value)
-		case err := <-partitionConsumer.Errors():
-			log.Printf("Consumer error: %v", err)
 		}
 	}
 }
 
-func (c *Consumer) processMessage(data []byte) {
+func (c *Consumer) saramaConfig() (*sarama.Config, error) {
+	strategy, err := balanceStrategy(c.cfg.RebalanceStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	initial, err := initialOffset(c.cfg.InitialOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = true
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{strategy}
+	// Offsets are only marked once a message has been persisted or dead-lettered,
+	// so auto-commit never moves past a message that was not handled.
+	config.Consumer.Offsets.Initial = initial
+	config.Consumer.Offsets.AutoCommit.Enable = true
+	return config, nil
+}
+
+// initialOffset maps the configured initial offset policy to a sarama offset.
+// The "timestamp" policy starts from the newest offset and is then refined per
+// partition in groupHandler.Setup.
+func initialOffset(policy string) (int64, error) {
+	switch policy {
+	case "", "newest", "timestamp":
+		return sarama.OffsetNewest, nil
+	case "oldest":
+		return sarama.OffsetOldest, nil
+	default:
+		return 0, fmt.Errorf("unknown initial offset policy %q", policy)
+	}
+}
+
+// balanceStrategy maps a configured strategy name to its sarama implementation.
+func balanceStrategy(name string) (sarama.BalanceStrategy, error) {
+	switch name {
+	case "", "sticky":
+		return sarama.NewBalanceStrategySticky(), nil
+	case "range":
+		return sarama.NewBalanceStrategyRange(), nil
+	case "roundrobin":
+		return sarama.NewBalanceStrategyRoundRobin(), nil
+	default:
+		return nil, fmt.Errorf("unknown rebalance strategy %q", name)
+	}
+}
+
+// processMessage handles a single message. A nil error means the message is done with:
+// it was either persisted or published to the DLQ, so its offset may be committed.
+// The message is traced as a child of the trace context found in its headers.
+func (c *Consumer) processMessage(ctx context.Context, msg *sarama.ConsumerMessage) (err error) {
+	ctx, span := c.startProcessSpan(ctx, msg)
+	defer func() { endSpan(span, err) }()
+
+	logger := c.messageLogger(msg)
+	order, attempts, err := c.process(ctx, logger, msg.Value)
+	if err == nil || errors.Is(err, repository.ErrDuplicateOrder) {
+		return nil
+	}
+	span.RecordError(err)
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// Shutting down or rebalancing: leave the message to be redelivered instead of dead-lettering it.
+		return ctxErr
+	}
+	if repository.IsTransient(err) {
+		c.pressure.recordFailure()
+		if c.pressure.engaged() {
+			logger.WarnContext(ctx, "Holding order until the database recovers",
+				logging.OrderUID(order.OrderUID), logging.Err(err))
+			return errBackpressure
+		}
+	}
+
+	return c.handleError(ctx, logger, msg, err, attempts)
+}
+
+// Reprocess runs a message through the same decode, validate and save steps as the consumer,
+// but returns failures to the caller instead of publishing them to the DLQ. It is used to
+// replay dead-lettered messages. An order that is already stored yields repository.ErrDuplicateOrder.
+func (c *Consumer) Reprocess(ctx context.Context, data []byte) error {
+	_, _, err := c.process(ctx, c.logger, data)
+	return err
+}
+
+// process decodes, validates and saves a message and caches the stored order. It returns the
+// decoded order and the number of save attempts along with the error of the failing step,
+// wrapped in a *processingError that records the step. Each step is logged to logger.
+func (c *Consumer) process(ctx context.Context, logger *slog.Logger, data []byte) (models.Order, int, error) {
 	var order models.Order
 
+	_, span := startStep(ctx, "order.unmarshal", semconv.MessagingMessagePayloadSizeBytes(len(data)))
 	err := json.Unmarshal(data, &order)
+	endSpan(span, err)
 	if err != nil {
-		log.Println("Error unmarshaling message:", err)
-		c.handleError(data, err)
-		return
+		logger.WarnContext(ctx, "Error unmarshaling message", logging.Err(err))
+		return order, 1, &processingError{category: CategoryDecode, err: err}
 	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("order.uid", order.OrderUID))
 
-	if err := order.Validate(); err != nil {
-		log.Printf("Validation failed for order %s: %v", order.OrderUID, err)
-		c.handleError(data, err)
-		return
+	_, span = startStep(ctx, "order.validate")
+	err = order.Validate()
+	endSpan(span, err)
+	if err != nil {
+		logger.WarnContext(ctx, "Validation failed", logging.OrderUID(order.OrderUID), logging.Err(err))
+		return order, 1, &processingError{category: CategoryValidation, err: err}
 	}
 
-	err = c.repo.SaveOrder(order)
+	saveCtx, span := startStep(ctx, "order.save")
+	attempts, err := c.saveWithRetry(saveCtx, logger, order)
+	span.SetAttributes(attribute.Int("order.save.attempts", attempts))
+	if errors.Is(err, repository.ErrDuplicateOrder) {
+		span.End()
+		c.cacheOrder(ctx, order)
+		c.metrics.IncMessagesTotal("duplicate")
+		logger.InfoContext(ctx, "Order already stored, skipping duplicate", logging.OrderUID(order.OrderUID))
+		return order, attempts, err
+	}
+	endSpan(span, err)
 	if err != nil {
-		log.Println("Error saving to DB:", err)
-		c.handleError(data, err)
-		return
+		logger.ErrorContext(ctx, "Error saving order", logging.OrderUID(order.OrderUID),
+			"attempts", attempts, logging.Err(err))
+		return order, attempts, &processingError{category: CategoryPersistence, err: err}
 	}
 
-	c.cache.Set(order.OrderUID, order)
+	c.pressure.recordSuccess()
+	c.cacheOrder(ctx, order)
 	c.metrics.IncMessagesTotal("success")
 
-	log.Printf("Order %s processed successfully", order.OrderUID)
+	logger.InfoContext(ctx, "Order processed successfully", logging.OrderUID(order.OrderUID))
+	return order, attempts, nil
 }
 
-func (c *Consumer) handleError(data []byte, err error) {
-	c.metrics.IncMessagesTotal("error")
+// cacheOrder stores a saved order in the cache.
+func (c *Consumer) cacheOrder(ctx context.Context, order models.Order) {
+	ctx, span := startStep(ctx, "cache.set", attribute.String("order.uid", order.OrderUID))
+	defer span.End()
+	c.cache.Set(ctx, order.OrderUID, order)
+}
 
-	// Send to DLQ
-	msg := &sarama.ProducerMessage{
-		Topic: c.dlqTopic,
-		Value: sarama.ByteEncoder(data),
-		Headers: []sarama.RecordHeader{
-			{Key: []byte("error"), Value: []byte(err.Error())},
-		},
-	}
+// handleError publishes a message that could not be processed to the DLQ. The DLQ record keeps
+// the original key and value and describes the failure and the source message in its headers,
+// along with the trace context of ctx.
+func (c *Consumer) handleError(ctx context.Context, logger *slog.Logger, msg *sarama.ConsumerMessage, err error,
+	attempts int) error {
+	c.metrics.IncMessagesTotal("error")
 
-	partition, offset, err := c.dlqProducer.SendMessage(msg)
+	dlqMsg := c.newDLQMessage(msg, err, attempts, time.Now())
+	ctx, span := StartPublishSpan(ctx, c.cfg.DLQTopic, attribute.String("error.category", errorCategory(err)))
+	InjectTraceContext(ctx, dlqMsg)
+	partition, offset, err := c.dlqProducer.SendMessage(dlqMsg)
+	endSpan(span, err)
 	if err != nil {
-		log.Printf("FAILED to send message to DLQ: %v", err)
-	} else {
-		log.Printf("Message sent to DLQ topic %s (partition: %d, offset: %d)", c.dlqTopic, partition, offset)
+		logger.ErrorContext(ctx, "Failed to send message to DLQ", logging.Err(err))
+		return fmt.Errorf("failed to send message to DLQ: %w", err)
 	}
+
+	logger.WarnContext(ctx, "Message sent to DLQ",
+		slog.Group("dlq", logging.KeyTopic, c.cfg.DLQTopic, logging.KeyPartition, partition, logging.KeyOffset, offset))
+	return nil
+}
+
+// messageLogger returns the consumer logger extended with the position of msg.
+func (c *Consumer) messageLogger(msg *sarama.ConsumerMessage) *slog.Logger {
+	return c.logger.With(logging.KeyTopic, msg.Topic, logging.KeyPartition, msg.Partition, logging.KeyOffset, msg.Offset)
 }
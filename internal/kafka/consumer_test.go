@@ -1,16 +1,26 @@
 package kafka
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/logging"
 	"wildberries-tech/internal/models"
+	"wildberries-tech/internal/repository"
 
+	"github.com/IBM/sarama"
 	"github.com/IBM/sarama/mocks"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // Mocks
@@ -18,12 +28,17 @@ type MockRepo struct {
 	mock.Mock
 }
 
-func (m *MockRepo) SaveOrder(order models.Order) error {
+func (m *MockRepo) SaveOrder(_ context.Context, order models.Order) error {
 	args := m.Called(order)
 	return args.Error(0)
 }
 
-func (m *MockRepo) GetOrder(uid string) (*models.Order, error) {
+func (m *MockRepo) SaveOrders(_ context.Context, orders []models.Order) error {
+	args := m.Called(orders)
+	return args.Error(0)
+}
+
+func (m *MockRepo) GetOrder(_ context.Context, uid string) (*models.Order, error) {
 	args := m.Called(uid)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -31,8 +46,24 @@ func (m *MockRepo) GetOrder(uid string) (*models.Order, error) {
 	return args.Get(0).(*models.Order), args.Error(1)
 }
 
-func (m *MockRepo) GetAllOrders() ([]models.Order, error) {
-	args := m.Called()
+func (m *MockRepo) ListOrders(_ context.Context, filter repository.OrderFilter, after *repository.Cursor,
+	limit int) (repository.OrderPage, error) {
+	args := m.Called(filter, after, limit)
+	return args.Get(0).(repository.OrderPage), args.Error(1)
+}
+
+func (m *MockRepo) FindOrdersByTrackNumber(_ context.Context, trackNumber string) ([]models.Order, error) {
+	args := m.Called(trackNumber)
+	return args.Get(0).([]models.Order), args.Error(1)
+}
+
+func (m *MockRepo) FindOrdersByTransaction(_ context.Context, transaction string) ([]models.Order, error) {
+	args := m.Called(transaction)
+	return args.Get(0).([]models.Order), args.Error(1)
+}
+
+func (m *MockRepo) FindOrdersByRID(_ context.Context, rid string) ([]models.Order, error) {
+	args := m.Called(rid)
 	return args.Get(0).([]models.Order), args.Error(1)
 }
 
@@ -48,16 +79,16 @@ type MockCache struct {
 	mock.Mock
 }
 
-func (m *MockCache) Set(uid string, order models.Order) {
+func (m *MockCache) Set(_ context.Context, uid string, order models.Order) {
 	m.Called(uid, order)
 }
 
-func (m *MockCache) Get(uid string) (models.Order, bool) {
+func (m *MockCache) Get(_ context.Context, uid string) (models.Order, bool) {
 	args := m.Called(uid)
 	return args.Get(0).(models.Order), args.Bool(1)
 }
 
-func (m *MockCache) LoadFromDB(orders []models.Order) {
+func (m *MockCache) LoadFromDB(_ context.Context, orders []models.Order) {
 	m.Called(orders)
 }
 
@@ -82,6 +113,163 @@ func (m *MockMetrics) ObserveHTTPDuration(method, path string, seconds float64)
 	m.Called(method, path, seconds)
 }
 
+func (m *MockMetrics) ObserveHTTPResponseSize(method, path string, bytes int) {
+	m.Called(method, path, bytes)
+}
+
+func (m *MockMetrics) IncHTTPInFlight() {
+	m.Called()
+}
+
+func (m *MockMetrics) DecHTTPInFlight() {
+	m.Called()
+}
+
+func (m *MockMetrics) IncCacheHits() {
+	m.Called()
+}
+
+func (m *MockMetrics) IncCacheMisses() {
+	m.Called()
+}
+
+func (m *MockMetrics) IncCacheSets() {
+	m.Called()
+}
+
+func (m *MockMetrics) IncCacheEvictions() {
+	m.Called()
+}
+
+func (m *MockMetrics) IncCacheExpirations() {
+	m.Called()
+}
+
+func (m *MockMetrics) SetCacheSize(entries int) {
+	m.Called(entries)
+}
+
+// fakeSession is a minimal sarama.ConsumerGroupSession that records marked offsets.
+type fakeSession struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	marked map[int32]int64
+}
+
+func newFakeSession(ctx context.Context) *fakeSession {
+	return &fakeSession{ctx: ctx, marked: make(map[int32]int64)}
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return nil }
+func (s *fakeSession) MemberID() string           { return "member-1" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) Commit()                    {}
+func (s *fakeSession) Context() context.Context   { return s.ctx }
+
+func (s *fakeSession) MarkOffset(_ string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset > s.marked[partition] {
+		s.marked[partition] = offset
+	}
+}
+
+func (s *fakeSession) ResetOffset(_ string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked[partition] = offset
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *fakeSession) markedOffset(partition int32) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.marked[partition]
+}
+
+// fakeClaim is a sarama.ConsumerGroupClaim backed by a buffered channel.
+type fakeClaim struct {
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(partition int32, values ...[]byte) *fakeClaim {
+	claim := &fakeClaim{partition: partition, messages: make(chan *sarama.ConsumerMessage, len(values))}
+	for i, value := range values {
+		claim.messages <- &sarama.ConsumerMessage{
+			Topic: "mock", Partition: partition, Offset: int64(i), Value: value,
+		}
+	}
+	close(claim.messages)
+	return claim
+}
+
+func (c *fakeClaim) Topic() string                            { return "mock" }
+func (c *fakeClaim) Partition() int32                         { return c.partition }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return int64(cap(c.messages)) }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func newMessage(value []byte) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     "mock",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("valid-uid"),
+		Value:     value,
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func testKafkaConfig() config.KafkaConfig {
+	return config.KafkaConfig{
+		Brokers:  []string{"mock"},
+		Topic:    "mock",
+		GroupID:  "mock-group",
+		DLQTopic: "dlq-mock",
+
+		ConsumerID: "consumer-1",
+
+		RetryMaxAttempts:    3,
+		RetryInitialBackoff: time.Millisecond,
+		RetryMaxBackoff:     5 * time.Millisecond,
+
+		PauseFailureThreshold: 3,
+
+		WorkerPoolSize:   4,
+		WorkerQueueDepth: 10,
+	}
+}
+
+// fakeProbe is a DatabaseProbe with a switchable result.
+type fakeProbe struct {
+	mu sync.Mutex
+	up bool
+}
+
+func (p *fakeProbe) DatabaseUp() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.up
+}
+
+func (p *fakeProbe) set(up bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.up = up
+}
+
+// fakePauser counts PauseAll/ResumeAll calls.
+type fakePauser struct {
+	paused, resumed int
+}
+
+func (p *fakePauser) PauseAll()  { p.paused++ }
+func (p *fakePauser) ResumeAll() { p.resumed++ }
+
 // Helper to create a fully valid order
 func createValidOrder() models.Order {
 	return models.Order{
@@ -138,7 +326,7 @@ func TestProcessMessage(t *testing.T) {
 	repo := new(MockRepo)
 	cache := new(MockCache)
 	metricsM := new(MockMetrics)
-	consumer := NewConsumer(repo, cache, metricsM, []string{"mock"}, "mock", "dlq-mock")
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
 
 	dlqProducer := mocks.NewSyncProducer(t, nil)
 	consumer.dlqProducer = dlqProducer
@@ -151,7 +339,7 @@ func TestProcessMessage(t *testing.T) {
 	cache.On("Set", validOrder.OrderUID, mock.AnythingOfType("models.Order")).Return()
 	metricsM.On("IncMessagesTotal", "success").Return()
 
-	consumer.processMessage(validJSON)
+	require.NoError(t, consumer.processMessage(context.Background(), newMessage(validJSON)))
 
 	repo.AssertCalled(t, "SaveOrder", mock.AnythingOfType("models.Order"))
 	cache.AssertCalled(t, "Set", validOrder.OrderUID, mock.AnythingOfType("models.Order"))
@@ -162,7 +350,7 @@ func TestProcessMessage_InvalidJSON(t *testing.T) {
 	repo := new(MockRepo)
 	cache := new(MockCache)
 	metricsM := new(MockMetrics)
-	consumer := NewConsumer(repo, cache, metricsM, []string{"mock"}, "mock", "dlq-mock")
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
 
 	dlqProducer := mocks.NewSyncProducer(t, nil)
 	dlqProducer.ExpectSendMessageAndSucceed()
@@ -172,7 +360,7 @@ func TestProcessMessage_InvalidJSON(t *testing.T) {
 
 	invalidJSON := []byte(`{invalid-json}`)
 
-	consumer.processMessage(invalidJSON)
+	require.NoError(t, consumer.processMessage(context.Background(), newMessage(invalidJSON)))
 
 	repo.AssertNotCalled(t, "SaveOrder")
 	cache.AssertNotCalled(t, "Set")
@@ -183,7 +371,7 @@ func TestProcessMessage_ValidationFail(t *testing.T) {
 	repo := new(MockRepo)
 	cache := new(MockCache)
 	metricsM := new(MockMetrics)
-	consumer := NewConsumer(repo, cache, metricsM, []string{"mock"}, "mock", "dlq-mock")
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
 
 	dlqProducer := mocks.NewSyncProducer(t, nil)
 	dlqProducer.ExpectSendMessageAndSucceed()
@@ -194,7 +382,7 @@ func TestProcessMessage_ValidationFail(t *testing.T) {
 	invalidOrder := models.Order{OrderUID: ""}
 	invalidJSON, _ := json.Marshal(invalidOrder)
 
-	consumer.processMessage(invalidJSON)
+	require.NoError(t, consumer.processMessage(context.Background(), newMessage(invalidJSON)))
 
 	repo.AssertNotCalled(t, "SaveOrder")
 	cache.AssertNotCalled(t, "Set")
@@ -205,7 +393,7 @@ func TestProcessMessage_RepoError(t *testing.T) {
 	repo := new(MockRepo)
 	cache := new(MockCache)
 	metricsM := new(MockMetrics)
-	consumer := NewConsumer(repo, cache, metricsM, []string{"mock"}, "mock", "dlq-mock")
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
 
 	dlqProducer := mocks.NewSyncProducer(t, nil)
 	dlqProducer.ExpectSendMessageAndSucceed()
@@ -217,9 +405,512 @@ func TestProcessMessage_RepoError(t *testing.T) {
 	repo.On("SaveOrder", mock.Anything).Return(errors.New("db error"))
 	metricsM.On("IncMessagesTotal", "error").Return()
 
-	consumer.processMessage(validJSON)
+	require.NoError(t, consumer.processMessage(context.Background(), newMessage(validJSON)))
 
 	repo.AssertCalled(t, "SaveOrder", mock.Anything)
 	cache.AssertNotCalled(t, "Set")
 	metricsM.AssertCalled(t, "IncMessagesTotal", "error")
 }
+
+func TestConsumeClaim_AllPartitions(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+	consumer.dlqProducer = mocks.NewSyncProducer(t, nil)
+
+	validJSON, _ := json.Marshal(createValidOrder())
+
+	repo.On("SaveOrder", mock.Anything).Return(nil)
+	cache.On("Set", mock.Anything, mock.Anything).Return()
+	metricsM.On("IncMessagesTotal", "success").Return()
+
+	handler := &groupHandler{consumer: consumer}
+	session := newFakeSession(context.Background())
+	require.NoError(t, handler.Setup(session))
+
+	for _, partition := range []int32{0, 1, 2} {
+		claim := newFakeClaim(partition, validJSON, validJSON)
+		require.NoError(t, handler.ConsumeClaim(session, claim))
+		assert.Equal(t, int64(2), session.markedOffset(partition))
+	}
+	require.NoError(t, handler.Cleanup(session))
+
+	repo.AssertNumberOfCalls(t, "SaveOrder", 6)
+}
+
+// membershipProbe is a DatabaseProbe that also records group membership changes.
+type membershipProbe struct {
+	fakeProbe
+	changes []bool
+}
+
+func (p *membershipProbe) SetGroupMember(joined bool) {
+	p.changes = append(p.changes, joined)
+}
+
+func TestGroupHandler_ReportsMembership(t *testing.T) {
+	probe := &membershipProbe{fakeProbe: fakeProbe{up: true}}
+	consumer := NewConsumer(new(MockRepo), new(MockCache), new(MockMetrics), testKafkaConfig(), probe, logging.Nop())
+
+	handler := &groupHandler{consumer: consumer}
+	session := newFakeSession(context.Background())
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.Cleanup(session))
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.Cleanup(session))
+
+	assert.Equal(t, []bool{true, true}, probe.changes, "a rebalance is not reported as a lost membership")
+}
+
+func TestProcessMessage_TransientErrorRetried(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+	consumer.dlqProducer = mocks.NewSyncProducer(t, nil)
+
+	validJSON, _ := json.Marshal(createValidOrder())
+
+	repo.On("SaveOrder", mock.Anything).Return(&pgconn.PgError{Code: "08006"}).Twice()
+	repo.On("SaveOrder", mock.Anything).Return(nil).Once()
+	cache.On("Set", mock.Anything, mock.Anything).Return()
+	metricsM.On("IncMessagesTotal", "success").Return()
+
+	require.NoError(t, consumer.processMessage(context.Background(), newMessage(validJSON)))
+
+	repo.AssertNumberOfCalls(t, "SaveOrder", 3)
+	metricsM.AssertCalled(t, "IncMessagesTotal", "success")
+}
+
+func TestProcessMessage_RetriesExhausted(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+
+	dlqProducer := mocks.NewSyncProducer(t, nil)
+	dlqProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		for _, header := range msg.Headers {
+			if string(header.Key) == "attempts" && string(header.Value) == "3" {
+				return nil
+			}
+		}
+		return fmt.Errorf("attempts header missing: %v", msg.Headers)
+	})
+	consumer.dlqProducer = dlqProducer
+
+	validJSON, _ := json.Marshal(createValidOrder())
+
+	repo.On("SaveOrder", mock.Anything).Return(&pgconn.PgError{Code: "40001"})
+	metricsM.On("IncMessagesTotal", "error").Return()
+
+	require.NoError(t, consumer.processMessage(context.Background(), newMessage(validJSON)))
+
+	repo.AssertNumberOfCalls(t, "SaveOrder", 3)
+	cache.AssertNotCalled(t, "Set")
+}
+
+func TestProcessMessage_PermanentErrorNotRetried(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+
+	dlqProducer := mocks.NewSyncProducer(t, nil)
+	dlqProducer.ExpectSendMessageAndSucceed()
+	consumer.dlqProducer = dlqProducer
+
+	validJSON, _ := json.Marshal(createValidOrder())
+
+	repo.On("SaveOrder", mock.Anything).Return(&pgconn.PgError{Code: "23502"})
+	metricsM.On("IncMessagesTotal", "error").Return()
+
+	require.NoError(t, consumer.processMessage(context.Background(), newMessage(validJSON)))
+
+	repo.AssertNumberOfCalls(t, "SaveOrder", 1)
+}
+
+func TestProcessMessage_HeldWhileDatabaseDown(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), &fakeProbe{up: false}, logging.Nop())
+	// No DLQ expectations: a held message must not be dead-lettered.
+	consumer.dlqProducer = mocks.NewSyncProducer(t, nil)
+
+	validJSON, _ := json.Marshal(createValidOrder())
+	repo.On("SaveOrder", mock.Anything).Return(&pgconn.PgError{Code: "08006"})
+
+	err := consumer.processMessage(context.Background(), newMessage(validJSON))
+	require.ErrorIs(t, err, errBackpressure)
+
+	metricsM.AssertNotCalled(t, "IncMessagesTotal", "error")
+	assert.True(t, consumer.pressure.engaged())
+}
+
+func TestBackpressure_PauseAndResume(t *testing.T) {
+	probe := &fakeProbe{up: true}
+	group := &fakePauser{}
+	pressure := newBackpressure(probe, 2, time.Hour, logging.Nop())
+	pressure.group = group
+
+	pressure.recordFailure()
+	assert.False(t, pressure.engaged(), "a single failure is below the threshold")
+
+	pressure.recordFailure()
+	assert.True(t, pressure.engaged())
+	assert.Equal(t, 1, group.paused)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, pressure.wait(ctx), context.DeadlineExceeded, "wait blocks while paused")
+
+	probe.set(false)
+	pressure.check()
+	assert.Equal(t, 0, group.resumed, "stays paused while the database is down")
+
+	probe.set(true)
+	pressure.check()
+	assert.Equal(t, 1, group.resumed)
+	assert.True(t, pressure.engaged(), "held messages are not dead-lettered until a save succeeds")
+	require.NoError(t, pressure.wait(context.Background()))
+
+	paused := group.paused
+	pressure.recordFailure()
+	assert.Equal(t, paused+1, group.paused, "a failure right after resuming pauses again")
+	require.ErrorIs(t, pressure.wait(ctx), context.DeadlineExceeded)
+
+	pressure.check()
+	assert.Equal(t, 2, group.resumed)
+	pressure.recordSuccess()
+	assert.False(t, pressure.engaged())
+}
+
+func TestBackoffDelay(t *testing.T) {
+	policy := backoff{initial: 100 * time.Millisecond, max: time.Second}
+
+	for attempt, ceiling := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		6: time.Second,
+	} {
+		delay := policy.delay(attempt)
+		assert.GreaterOrEqual(t, delay, ceiling/2)
+		assert.LessOrEqual(t, delay, ceiling)
+	}
+}
+
+func TestProcessMessage_Duplicate(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+	consumer.dlqProducer = mocks.NewSyncProducer(t, nil)
+
+	validOrder := createValidOrder()
+	validJSON, _ := json.Marshal(validOrder)
+
+	repo.On("SaveOrder", mock.Anything).Return(repository.ErrDuplicateOrder)
+	cache.On("Set", validOrder.OrderUID, mock.AnythingOfType("models.Order")).Return()
+	metricsM.On("IncMessagesTotal", "duplicate").Return()
+
+	require.NoError(t, consumer.processMessage(context.Background(), newMessage(validJSON)))
+
+	metricsM.AssertCalled(t, "IncMessagesTotal", "duplicate")
+	metricsM.AssertNotCalled(t, "IncMessagesTotal", "error")
+}
+
+func TestProcessMessage_Conflict(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+
+	dlqProducer := mocks.NewSyncProducer(t, nil)
+	dlqProducer.ExpectSendMessageAndSucceed()
+	consumer.dlqProducer = dlqProducer
+
+	validJSON, _ := json.Marshal(createValidOrder())
+
+	repo.On("SaveOrder", mock.Anything).Return(fmt.Errorf("%w: valid-uid", repository.ErrOrderConflict))
+	metricsM.On("IncMessagesTotal", "error").Return()
+
+	require.NoError(t, consumer.processMessage(context.Background(), newMessage(validJSON)))
+
+	cache.AssertNotCalled(t, "Set")
+	metricsM.AssertCalled(t, "IncMessagesTotal", "error")
+}
+
+func TestReprocess_DoesNotDeadLetter(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+	// No DLQ expectations: replay failures are reported to the caller.
+	consumer.dlqProducer = mocks.NewSyncProducer(t, nil)
+
+	validJSON, _ := json.Marshal(createValidOrder())
+	repo.On("SaveOrder", mock.Anything).Return(fmt.Errorf("%w: valid-uid", repository.ErrOrderConflict))
+
+	err := consumer.Reprocess(context.Background(), validJSON)
+	require.ErrorIs(t, err, repository.ErrOrderConflict)
+
+	err = consumer.Reprocess(context.Background(), []byte(`{invalid-json}`))
+	require.Error(t, err)
+
+	metricsM.AssertNotCalled(t, "IncMessagesTotal", "error")
+}
+
+func TestProcessMessage_DLQFailure(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+
+	dlqProducer := mocks.NewSyncProducer(t, nil)
+	dlqProducer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	consumer.dlqProducer = dlqProducer
+
+	metricsM.On("IncMessagesTotal", "error").Return()
+
+	err := consumer.processMessage(context.Background(), newMessage([]byte(`{invalid-json}`)))
+	require.ErrorIs(t, err, sarama.ErrOutOfBrokers)
+}
+
+func TestConsumeClaim_UnhandledMessageNotMarked(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+
+	dlqProducer := mocks.NewSyncProducer(t, nil)
+	dlqProducer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	consumer.dlqProducer = dlqProducer
+
+	validJSON, _ := json.Marshal(createValidOrder())
+	repo.On("SaveOrder", mock.Anything).Return(errors.New("db error"))
+	metricsM.On("IncMessagesTotal", "error").Return()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	session := newFakeSession(ctx)
+
+	handler := &groupHandler{consumer: consumer}
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.ConsumeClaim(session, newFakeClaim(0, validJSON)))
+	require.NoError(t, handler.Cleanup(session))
+
+	assert.Equal(t, int64(0), session.markedOffset(0), "offset must not advance past an unhandled message")
+}
+
+func TestInitialOffset(t *testing.T) {
+	tests := map[string]int64{
+		"":          sarama.OffsetNewest,
+		"newest":    sarama.OffsetNewest,
+		"timestamp": sarama.OffsetNewest,
+		"oldest":    sarama.OffsetOldest,
+	}
+	for policy, expected := range tests {
+		offset, err := initialOffset(policy)
+		require.NoError(t, err)
+		assert.Equal(t, expected, offset, policy)
+	}
+
+	_, err := initialOffset("latest")
+	assert.Error(t, err)
+}
+
+func TestConsumeClaim_OrderedPerKey(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+	consumer.dlqProducer = mocks.NewSyncProducer(t, nil)
+
+	var mu sync.Mutex
+	saved := make(map[string][]string)
+	repo.On("SaveOrder", mock.Anything).Run(func(args mock.Arguments) {
+		order := args.Get(0).(models.Order)
+		mu.Lock()
+		defer mu.Unlock()
+		saved[order.OrderUID] = append(saved[order.OrderUID], order.TrackNumber)
+	}).Return(nil)
+	cache.On("Set", mock.Anything, mock.Anything).Return()
+	metricsM.On("IncMessagesTotal", "success").Return()
+
+	// Three orders, each updated five times; updates of the same order must be saved in order.
+	var values [][]byte
+	for version := 0; version < 5; version++ {
+		for _, uid := range []string{"uid-a", "uid-b", "uid-c"} {
+			order := createValidOrder()
+			order.OrderUID = uid
+			order.TrackNumber = fmt.Sprintf("v%d", version)
+			value, _ := json.Marshal(order)
+			values = append(values, value)
+		}
+	}
+
+	handler := &groupHandler{consumer: consumer}
+	session := newFakeSession(context.Background())
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.ConsumeClaim(session, newFakeClaim(0, values...)))
+	require.NoError(t, handler.Cleanup(session))
+
+	assert.Equal(t, int64(len(values)), session.markedOffset(0))
+	for _, uid := range []string{"uid-a", "uid-b", "uid-c"} {
+		assert.Equal(t, []string{"v0", "v1", "v2", "v3", "v4"}, saved[uid], uid)
+	}
+}
+
+// batchTestValues returns n valid orders with distinct UIDs, encoded as messages.
+func batchTestValues(n int) [][]byte {
+	values := make([][]byte, n)
+	for i := range values {
+		order := createValidOrder()
+		order.OrderUID = fmt.Sprintf("uid-%d", i)
+		values[i], _ = json.Marshal(order)
+	}
+	return values
+}
+
+func TestConsumeClaim_SavesBatches(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	cfg := testKafkaConfig()
+	cfg.WorkerPoolSize = 1
+	cfg.BatchSize = 10
+	cfg.BatchLinger = time.Second
+	consumer := NewConsumer(repo, cache, metricsM, cfg, nil, logging.Nop())
+	consumer.dlqProducer = mocks.NewSyncProducer(t, nil)
+
+	repo.On("SaveOrders", mock.MatchedBy(func(orders []models.Order) bool {
+		return len(orders) == 10
+	})).Return(nil).Twice()
+	cache.On("Set", mock.Anything, mock.Anything).Return()
+	metricsM.On("IncMessagesTotal", "success").Return()
+
+	handler := &groupHandler{consumer: consumer}
+	session := newFakeSession(context.Background())
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.ConsumeClaim(session, newFakeClaim(0, batchTestValues(20)...)))
+	require.NoError(t, handler.Cleanup(session))
+
+	assert.Equal(t, int64(20), session.markedOffset(0))
+	repo.AssertNumberOfCalls(t, "SaveOrders", 2)
+	repo.AssertNotCalled(t, "SaveOrder", mock.Anything)
+	metricsM.AssertNumberOfCalls(t, "IncMessagesTotal", 20)
+}
+
+func TestConsumeClaim_BatchFailureFallsBackToSingleSaves(t *testing.T) {
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	cfg := testKafkaConfig()
+	cfg.WorkerPoolSize = 1
+	cfg.BatchSize = 10
+	cfg.BatchLinger = 50 * time.Millisecond
+	consumer := NewConsumer(repo, cache, metricsM, cfg, nil, logging.Nop())
+	dlq := mocks.NewSyncProducer(t, nil)
+	dlq.ExpectSendMessageAndSucceed()
+	consumer.dlqProducer = dlq
+
+	repo.On("SaveOrders", mock.Anything).Return(repository.ErrBatchConflict)
+	repo.On("SaveOrder", mock.MatchedBy(func(o models.Order) bool { return o.OrderUID == "uid-1" })).
+		Return(repository.ErrDuplicateOrder)
+	repo.On("SaveOrder", mock.Anything).Return(nil)
+	cache.On("Set", mock.Anything, mock.Anything).Return()
+	metricsM.On("IncMessagesTotal", mock.Anything).Return()
+
+	values := append(batchTestValues(3), []byte("invalid json"))
+	handler := &groupHandler{consumer: consumer}
+	session := newFakeSession(context.Background())
+	require.NoError(t, handler.Setup(session))
+	require.NoError(t, handler.ConsumeClaim(session, newFakeClaim(0, values...)))
+	require.NoError(t, handler.Cleanup(session))
+
+	assert.Equal(t, int64(4), session.markedOffset(0))
+	repo.AssertNumberOfCalls(t, "SaveOrders", 1)
+	repo.AssertNumberOfCalls(t, "SaveOrder", 3)
+	metricsM.AssertCalled(t, "IncMessagesTotal", "duplicate")
+	metricsM.AssertCalled(t, "IncMessagesTotal", "error")
+	metricsM.AssertNumberOfCalls(t, "IncMessagesTotal", 4)
+}
+
+func TestOffsetTracker_CommitsContiguousRanges(t *testing.T) {
+	tracker := &offsetTracker{}
+	for _, offset := range []int64{10, 11, 12, 14} {
+		tracker.add(offset)
+	}
+
+	_, advanced := tracker.complete(11, true)
+	assert.False(t, advanced, "offset 10 is still in flight")
+
+	_, advanced = tracker.complete(14, true)
+	assert.False(t, advanced)
+
+	next, advanced := tracker.complete(10, true)
+	assert.True(t, advanced)
+	assert.Equal(t, int64(12), next, "10 and 11 are done, 12 is not")
+
+	_, advanced = tracker.complete(12, false)
+	assert.False(t, advanced, "an abandoned message blocks the commit")
+
+	tracker.wait()
+}
+
+func TestLagTracker(t *testing.T) {
+	lag := newLagTracker()
+	lag.fetched(0, 10, 15)
+	lag.fetched(1, 0, 3)
+	assert.Equal(t, int64(5+3), lag.total())
+
+	lag.handled(0, 12)
+	lag.fetched(0, 11, 20)
+	assert.Equal(t, int64(8+3), lag.total(), "a later fetch raises the high-water mark only")
+
+	lag.handled(1, 3)
+	assert.Equal(t, int64(8), lag.total())
+
+	newest := offsetsFunc(func(_ string, partition int32, _ int64) (int64, error) {
+		if partition == 1 {
+			return 0, errors.New("leader not available")
+		}
+		return 30, nil
+	})
+	assert.Error(t, lag.refresh(newest, "orders"))
+	assert.Equal(t, int64(18), lag.total(), "refreshed marks count without fetching")
+
+	lag.reset()
+	assert.Zero(t, lag.total())
+}
+
+type offsetsFunc func(topic string, partition int32, time int64) (int64, error)
+
+func (f offsetsFunc) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	return f(topic, partition, time)
+}
+
+func TestWorkerPool_RoutesByKeyOrOrderUID(t *testing.T) {
+	pool := newWorkerPool(8, 1, 1, 0, func([]job) {})
+	defer pool.stop()
+
+	keyed := &sarama.ConsumerMessage{Key: []byte("uid-1"), Offset: 1}
+	assert.Equal(t, pool.route(keyed), pool.route(&sarama.ConsumerMessage{Key: []byte("uid-1"), Offset: 2}))
+
+	unkeyed := &sarama.ConsumerMessage{Value: []byte(`{"order_uid":"uid-1"}`), Offset: 3}
+	assert.Equal(t, pool.route(keyed), pool.route(unkeyed), "the order UID stands in for a missing key")
+}
+
+func TestBalanceStrategy(t *testing.T) {
+	for _, name := range []string{"", "sticky", "range", "roundrobin"} {
+		strategy, err := balanceStrategy(name)
+		require.NoError(t, err)
+		assert.NotNil(t, strategy)
+	}
+
+	_, err := balanceStrategy("random")
+	assert.Error(t, err)
+}
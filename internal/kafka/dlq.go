@@ -0,0 +1,284 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-playground/validator/v10"
+)
+
+// Headers attached to messages published to the DLQ.
+const (
+	// HeaderError holds the error that caused the message to be dead-lettered.
+	HeaderError = "error"
+	// HeaderErrorCategory holds the processing step that failed, one of the Category constants.
+	HeaderErrorCategory = "error_category"
+	// HeaderValidationFields holds the comma-separated fields that failed validation.
+	HeaderValidationFields = "validation_fields"
+	// HeaderAttempts holds the number of processing attempts made before dead-lettering.
+	HeaderAttempts = "attempts"
+	// HeaderSourceTopic, HeaderSourcePartition, HeaderSourceOffset, HeaderSourceKey and
+	// HeaderSourceTimestamp describe the original message.
+	HeaderSourceTopic     = "source_topic"
+	HeaderSourcePartition = "source_partition"
+	HeaderSourceOffset    = "source_offset"
+	HeaderSourceKey       = "source_key"
+	HeaderSourceTimestamp = "source_timestamp"
+	// HeaderConsumerID identifies the service instance that dead-lettered the message.
+	HeaderConsumerID = "consumer_id"
+	// HeaderFailedAt holds the time processing failed, in RFC 3339 format.
+	HeaderFailedAt = "failed_at"
+	// HeaderReplayedFrom marks a message replayed from the DLQ with its DLQ position.
+	HeaderReplayedFrom = "replayed_from"
+)
+
+// Processing steps a message can fail in.
+const (
+	CategoryDecode      = "decode"
+	CategoryValidation  = "validation"
+	CategoryPersistence = "persistence"
+)
+
+// processingError is a failure of a single processing step.
+type processingError struct {
+	category string
+	err      error
+}
+
+func (e *processingError) Error() string { return e.err.Error() }
+func (e *processingError) Unwrap() error { return e.err }
+
+// errorCategory returns the processing step err failed in, or "unknown".
+func errorCategory(err error) string {
+	var pe *processingError
+	if errors.As(err, &pe) {
+		return pe.category
+	}
+	return "unknown"
+}
+
+// validationFields returns the namespaced fields (such as "Delivery.Email" or "Items[0].Price")
+// that failed validation, without the root struct name.
+func validationFields(err error) []string {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return nil
+	}
+
+	fields := make([]string, 0, len(verrs))
+	for _, fe := range verrs {
+		namespace := fe.Namespace()
+		if i := strings.IndexByte(namespace, '.'); i >= 0 {
+			namespace = namespace[i+1:]
+		}
+		fields = append(fields, namespace)
+	}
+	return fields
+}
+
+// newDLQMessage builds the DLQ record for msg, which failed with err after the given number of attempts.
+func (c *Consumer) newDLQMessage(msg *sarama.ConsumerMessage, err error, attempts int,
+	failedAt time.Time) *sarama.ProducerMessage {
+	headers := []sarama.RecordHeader{
+		{Key: []byte(HeaderError), Value: []byte(err.Error())},
+		{Key: []byte(HeaderErrorCategory), Value: []byte(errorCategory(err))},
+		{Key: []byte(HeaderAttempts), Value: []byte(strconv.Itoa(attempts))},
+		{Key: []byte(HeaderSourceTopic), Value: []byte(msg.Topic)},
+		{Key: []byte(HeaderSourcePartition), Value: []byte(strconv.FormatInt(int64(msg.Partition), 10))},
+		{Key: []byte(HeaderSourceOffset), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		{Key: []byte(HeaderSourceKey), Value: msg.Key},
+		{Key: []byte(HeaderSourceTimestamp), Value: []byte(msg.Timestamp.UTC().Format(time.RFC3339Nano))},
+		{Key: []byte(HeaderConsumerID), Value: []byte(c.cfg.ConsumerID)},
+		{Key: []byte(HeaderFailedAt), Value: []byte(failedAt.UTC().Format(time.RFC3339Nano))},
+	}
+	if fields := validationFields(err); len(fields) > 0 {
+		headers = append(headers, sarama.RecordHeader{
+			Key: []byte(HeaderValidationFields), Value: []byte(strings.Join(fields, ",")),
+		})
+	}
+
+	dlqMsg := &sarama.ProducerMessage{
+		Topic:   c.cfg.DLQTopic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if len(msg.Key) > 0 {
+		dlqMsg.Key = sarama.ByteEncoder(msg.Key)
+	}
+	return dlqMsg
+}
+
+// dlqReadIdleTimeout bounds how long ReadDLQ waits for the next message of a partition.
+// The last offsets below the high water mark may belong to transaction markers that are never delivered.
+const dlqReadIdleTimeout = 5 * time.Second
+
+// DLQMessage is a dead-lettered message together with its decoded diagnostic headers.
+type DLQMessage struct {
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+
+	Error            string
+	Category         string
+	ValidationFields []string
+	Attempts         int
+	ConsumerID       string
+	// FailedAt falls back to the DLQ record timestamp for messages without a failed_at header.
+	FailedAt time.Time
+
+	SourceTopic     string
+	SourcePartition int32
+	SourceOffset    int64
+	SourceTimestamp time.Time
+
+	OrderUID string
+}
+
+// ParseDLQMessage decodes the headers of a DLQ record. The order UID is extracted from
+// the payload on a best-effort basis and is empty if the payload is not a valid order.
+func ParseDLQMessage(msg *sarama.ConsumerMessage) DLQMessage {
+	m := DLQMessage{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   make(map[string]string, len(msg.Headers)),
+	}
+
+	for _, header := range msg.Headers {
+		if header != nil {
+			m.Headers[string(header.Key)] = string(header.Value)
+		}
+	}
+	m.Error = m.Headers[HeaderError]
+	m.Category = m.Headers[HeaderErrorCategory]
+	if fields := m.Headers[HeaderValidationFields]; fields != "" {
+		m.ValidationFields = strings.Split(fields, ",")
+	}
+	m.Attempts, _ = strconv.Atoi(m.Headers[HeaderAttempts])
+	m.ConsumerID = m.Headers[HeaderConsumerID]
+	m.FailedAt = parseHeaderTime(m.Headers[HeaderFailedAt], msg.Timestamp)
+
+	m.SourceTopic = m.Headers[HeaderSourceTopic]
+	if partition, err := strconv.ParseInt(m.Headers[HeaderSourcePartition], 10, 32); err == nil {
+		m.SourcePartition = int32(partition)
+	}
+	m.SourceOffset, _ = strconv.ParseInt(m.Headers[HeaderSourceOffset], 10, 64)
+	m.SourceTimestamp = parseHeaderTime(m.Headers[HeaderSourceTimestamp], time.Time{})
+
+	var payload struct {
+		OrderUID string `json:"order_uid"`
+	}
+	if err := json.Unmarshal(msg.Value, &payload); err == nil {
+		m.OrderUID = payload.OrderUID
+	}
+
+	return m
+}
+
+func parseHeaderTime(value string, fallback time.Time) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return fallback
+	}
+	return t
+}
+
+// DLQFilter selects DLQ messages. Zero-valued fields match everything.
+type DLQFilter struct {
+	// ErrorContains matches messages whose error header contains the text, case-insensitively.
+	ErrorContains string
+	// Since and Until bound the time the message was dead-lettered.
+	Since time.Time
+	Until time.Time
+	// OrderUID matches messages carrying the given order.
+	OrderUID string
+}
+
+// Matches reports whether m satisfies every condition of the filter.
+func (f DLQFilter) Matches(m DLQMessage) bool {
+	if f.ErrorContains != "" && !strings.Contains(strings.ToLower(m.Error), strings.ToLower(f.ErrorContains)) {
+		return false
+	}
+	if !f.Since.IsZero() && m.FailedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !m.FailedAt.Before(f.Until) {
+		return false
+	}
+	if f.OrderUID != "" && m.OrderUID != f.OrderUID {
+		return false
+	}
+	return true
+}
+
+// ReadDLQ reads every message currently stored in the DLQ topic, partition by partition, and
+// calls fn for each of them. Messages produced after ReadDLQ started are not read.
+func ReadDLQ(ctx context.Context, client sarama.Client, topic string, fn func(DLQMessage) error) error {
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("error creating consumer: %w", err)
+	}
+	defer func() { _ = consumer.Close() }()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return fmt.Errorf("error listing partitions of %s: %w", topic, err)
+	}
+
+	for _, partition := range partitions {
+		if err := readDLQPartition(ctx, client, consumer, topic, partition, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readDLQPartition(ctx context.Context, client sarama.Client, consumer sarama.Consumer,
+	topic string, partition int32, fn func(DLQMessage) error) error {
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return fmt.Errorf("error getting oldest offset of %s/%d: %w", topic, partition, err)
+	}
+	end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return fmt.Errorf("error getting newest offset of %s/%d: %w", topic, partition, err)
+	}
+	if oldest >= end {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partition, oldest)
+	if err != nil {
+		return fmt.Errorf("error consuming %s/%d: %w", topic, partition, err)
+	}
+	defer func() { _ = pc.Close() }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-pc.Messages():
+			if err := fn(ParseDLQMessage(msg)); err != nil {
+				return err
+			}
+			if msg.Offset+1 >= end {
+				return nil
+			}
+		case err := <-pc.Errors():
+			return fmt.Errorf("error reading %s/%d: %w", topic, partition, err)
+		case <-time.After(dlqReadIdleTimeout):
+			return nil
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"wildberries-tech/internal/logging"
+)
+
+func TestParseDLQMessage(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	msg := &sarama.ConsumerMessage{
+		Partition: 2,
+		Offset:    41,
+		Timestamp: ts,
+		Value:     []byte(`{"order_uid":"uid-1","track_number":"T1"}`),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(HeaderError), Value: []byte("failed to create order: connection refused")},
+			{Key: []byte(HeaderAttempts), Value: []byte("5")},
+		},
+	}
+
+	m := ParseDLQMessage(msg)
+
+	assert.Equal(t, int32(2), m.Partition)
+	assert.Equal(t, int64(41), m.Offset)
+	assert.Equal(t, ts, m.Timestamp)
+	assert.Equal(t, "uid-1", m.OrderUID)
+	assert.Equal(t, "failed to create order: connection refused", m.Error)
+	assert.Equal(t, 5, m.Attempts)
+}
+
+func TestParseDLQMessage_InvalidPayload(t *testing.T) {
+	m := ParseDLQMessage(&sarama.ConsumerMessage{Value: []byte(`{invalid-json}`)})
+
+	assert.Empty(t, m.OrderUID)
+	assert.Empty(t, m.Error)
+	assert.Zero(t, m.Attempts)
+}
+
+func TestDLQFilter_Matches(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := DLQMessage{FailedAt: ts, Error: "Key: 'Order.Delivery.Email' failed", OrderUID: "uid-1"}
+
+	tests := []struct {
+		name   string
+		filter DLQFilter
+		want   bool
+	}{
+		{"empty filter", DLQFilter{}, true},
+		{"error text", DLQFilter{ErrorContains: "delivery.email"}, true},
+		{"other error text", DLQFilter{ErrorContains: "connection"}, false},
+		{"inside range", DLQFilter{Since: ts.Add(-time.Hour), Until: ts.Add(time.Hour)}, true},
+		{"since is inclusive", DLQFilter{Since: ts}, true},
+		{"until is exclusive", DLQFilter{Until: ts}, false},
+		{"before range", DLQFilter{Since: ts.Add(time.Minute)}, false},
+		{"order uid", DLQFilter{OrderUID: "uid-1"}, true},
+		{"other order uid", DLQFilter{OrderUID: "uid-2"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Matches(m))
+		})
+	}
+}
+
+func TestNewDLQMessage_RoundTrip(t *testing.T) {
+	consumer := NewConsumer(new(MockRepo), new(MockCache), new(MockMetrics), testKafkaConfig(), nil, logging.Nop())
+
+	order := createValidOrder()
+	order.Delivery.Email = "not-an-email"
+	order.Items[0].Price = -1
+	validationErr := order.Validate()
+	require.Error(t, validationErr)
+
+	value, _ := json.Marshal(order)
+	source := newMessage(value)
+	failedAt := time.Date(2024, 5, 1, 12, 0, 5, 0, time.UTC)
+
+	produced := consumer.newDLQMessage(source,
+		&processingError{category: CategoryValidation, err: validationErr}, 1, failedAt)
+	assert.Equal(t, "dlq-mock", produced.Topic)
+
+	// Convert the produced record into what a DLQ reader receives.
+	key, _ := produced.Key.Encode()
+	payload, _ := produced.Value.Encode()
+	received := &sarama.ConsumerMessage{Key: key, Value: payload, Timestamp: failedAt}
+	for i := range produced.Headers {
+		received.Headers = append(received.Headers, &produced.Headers[i])
+	}
+
+	m := ParseDLQMessage(received)
+
+	assert.Equal(t, CategoryValidation, m.Category)
+	assert.ElementsMatch(t, []string{"Delivery.Email", "Items[0].Price"}, m.ValidationFields)
+	assert.Equal(t, 1, m.Attempts)
+	assert.Equal(t, "consumer-1", m.ConsumerID)
+	assert.Equal(t, failedAt, m.FailedAt)
+	assert.Equal(t, "mock", m.SourceTopic)
+	assert.Equal(t, int32(3), m.SourcePartition)
+	assert.Equal(t, int64(42), m.SourceOffset)
+	assert.Equal(t, source.Timestamp, m.SourceTimestamp)
+	assert.Equal(t, "valid-uid", m.Headers[HeaderSourceKey])
+	assert.Equal(t, []byte("valid-uid"), m.Key)
+	assert.Equal(t, order.OrderUID, m.OrderUID)
+}
+
+func TestErrorCategory(t *testing.T) {
+	assert.Equal(t, CategoryDecode, errorCategory(&processingError{category: CategoryDecode, err: errors.New("x")}))
+	assert.Equal(t, "unknown", errorCategory(errors.New("x")))
+	assert.Nil(t, validationFields(errors.New("x")))
+}
@@ -0,0 +1,170 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"wildberries-tech/internal/logging"
+)
+
+// groupHandler implements sarama.ConsumerGroupHandler for a single Consumer.
+// sarama calls ConsumeClaim in a separate goroutine for every partition claimed in a session;
+// the claims hand their messages to a worker pool that lives as long as the session.
+type groupHandler struct {
+	consumer *Consumer
+	client   sarama.Client
+	pool     *workerPool
+}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim.
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.consumer.logger.Info("Consumer group session started",
+		"member", session.MemberID(), "generation", session.GenerationID(), "claims", session.Claims())
+
+	if h.consumer.cfg.InitialOffset == "timestamp" {
+		if err := h.seekToTimestamp(session); err != nil {
+			return err
+		}
+	}
+
+	cfg := h.consumer.cfg
+	h.pool = newWorkerPool(cfg.WorkerPoolSize, cfg.WorkerQueueDepth, cfg.BatchSize, cfg.BatchLinger,
+		func(jobs []job) { h.handleBatch(session, jobs) })
+	h.consumer.setGroupMember(true)
+	return nil
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited.
+// The consumer stays a group member until rejoining fails or Start returns.
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	h.consumer.lag.reset()
+	if h.pool != nil {
+		h.pool.stop()
+		h.pool = nil
+	}
+
+	h.consumer.logger.Info("Consumer group session ended",
+		"member", session.MemberID(), "generation", session.GenerationID())
+	return nil
+}
+
+// ConsumeClaim dispatches the messages of a single partition to the worker pool until the claim
+// is revoked or the session ends. Before returning it waits for the dispatched messages to finish,
+// so that their offsets are marked within the session that owns the partition.
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	tracker := &offsetTracker{}
+	defer tracker.wait()
+
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			h.consumer.lag.fetched(msg.Partition, msg.Offset, claim.HighWaterMarkOffset())
+			tracker.add(msg.Offset)
+			if !h.pool.submit(ctx, job{msg: msg, tracker: tracker}) {
+				tracker.complete(msg.Offset, false)
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// handleBatch saves a batch of jobs taken from one worker queue and marks their offsets.
+// Messages the batch did not save are then handled one by one, in queue order.
+func (h *groupHandler) handleBatch(session sarama.ConsumerGroupSession, jobs []job) {
+	ctx := session.Context()
+
+	saved := make([]bool, len(jobs))
+	if len(jobs) > 1 && h.consumer.pressure.wait(ctx) == nil {
+		msgs := make([]*sarama.ConsumerMessage, len(jobs))
+		for i, j := range jobs {
+			msgs[i] = j.msg
+		}
+		saved = h.consumer.saveBatch(ctx, msgs)
+	}
+
+	for i, j := range jobs {
+		done := saved[i] || h.handle(ctx, j.msg)
+		if next, advanced := j.tracker.complete(j.msg.Offset, done); advanced {
+			session.MarkOffset(j.msg.Topic, j.msg.Partition, next, "")
+			h.consumer.lag.handled(j.msg.Partition, next)
+		}
+	}
+}
+
+// handle processes msg until it has been persisted or dead-lettered. It returns false if the
+// session ended first; the message is then redelivered to whichever member owns the partition next.
+func (h *groupHandler) handle(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	for {
+		// Messages fetched before a pause are still delivered; hold them until fetching resumes.
+		if err := h.consumer.pressure.wait(ctx); err != nil {
+			return false
+		}
+
+		err := h.consumer.processMessage(ctx, msg)
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, errBackpressure) {
+			continue
+		}
+
+		h.consumer.messageLogger(msg).WarnContext(ctx, "Message not handled, retrying",
+			"retry_in", redeliveryDelay, logging.Err(err))
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(redeliveryDelay):
+		}
+	}
+}
+
+// seekToTimestamp positions every claimed partition that has no committed offset yet
+// at the first message produced at or after the configured InitialOffsetTime.
+func (h *groupHandler) seekToTimestamp(session sarama.ConsumerGroupSession) error {
+	cfg := h.consumer.cfg
+
+	admin, err := sarama.NewClusterAdminFromClient(h.client)
+	if err != nil {
+		return fmt.Errorf("error creating cluster admin: %w", err)
+	}
+	// Closing the admin would also close the shared client, so it is left to the garbage collector.
+
+	claims := session.Claims()
+	committed, err := admin.ListConsumerGroupOffsets(cfg.GroupID, claims)
+	if err != nil {
+		return fmt.Errorf("error fetching committed offsets: %w", err)
+	}
+
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			if block := committed.GetBlock(topic, partition); block != nil && block.Offset >= 0 {
+				continue
+			}
+
+			offset, err := h.client.GetOffset(topic, partition, cfg.InitialOffsetTime.UnixMilli())
+			if err != nil {
+				return fmt.Errorf("error resolving offset for %s/%d: %w", topic, partition, err)
+			}
+			if offset < 0 {
+				// No message at or after the timestamp: keep the newest offset.
+				continue
+			}
+
+			h.consumer.logger.Info("Starting partition at timestamp offset",
+				logging.KeyTopic, topic, logging.KeyPartition, partition, logging.KeyOffset, offset,
+				"timestamp", cfg.InitialOffsetTime.Format(time.RFC3339))
+			session.MarkOffset(topic, partition, offset, "")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// offsetSource looks up the offsets of partitions. sarama.Client implements it.
+type offsetSource interface {
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+// lagTracker follows, for every partition fetched from in the current session, the high-water
+// mark of the partition and the offset up to which messages have been handled. High-water marks
+// arrive with fetched messages and are refreshed from the brokers, so that lag keeps growing
+// while fetching is paused.
+type lagTracker struct {
+	mu         sync.Mutex
+	partitions map[int32]partitionLag
+}
+
+type partitionLag struct {
+	highWater int64
+	next      int64
+}
+
+func newLagTracker() *lagTracker {
+	return &lagTracker{partitions: make(map[int32]partitionLag)}
+}
+
+// fetched records a fetched message and the high-water mark reported along with it.
+// The first message fetched from a partition in a session starts tracking the partition.
+func (t *lagTracker) fetched(partition int32, offset, highWater int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.partitions[partition]
+	if !ok {
+		p.next = offset
+	}
+	p.highWater = max(p.highWater, highWater)
+	t.partitions[partition] = p
+}
+
+// handled records that all messages of a partition before offset next have been handled.
+func (t *lagTracker) handled(partition int32, next int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.partitions[partition]
+	p.next = max(p.next, next)
+	t.partitions[partition] = p
+}
+
+// refresh raises the high-water marks of the tracked partitions of topic to the newest offsets
+// reported by source. Partitions whose offset cannot be looked up keep their last known mark.
+func (t *lagTracker) refresh(source offsetSource, topic string) error {
+	t.mu.Lock()
+	partitions := make([]int32, 0, len(t.partitions))
+	for partition := range t.partitions {
+		partitions = append(partitions, partition)
+	}
+	t.mu.Unlock()
+
+	var errs []error
+	for _, partition := range partitions {
+		highWater, err := source.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error getting newest offset of partition %d: %w", partition, err))
+			continue
+		}
+
+		t.mu.Lock()
+		// A partition forgotten by reset in the meantime is not tracked again.
+		if p, ok := t.partitions[partition]; ok {
+			p.highWater = max(p.highWater, highWater)
+			t.partitions[partition] = p
+		}
+		t.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// reset forgets all partitions at the end of a session.
+func (t *lagTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.partitions)
+}
+
+// total returns the number of messages not yet handled across all claimed partitions.
+func (t *lagTracker) total() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var lag int64
+	for _, p := range t.partitions {
+		lag += max(p.highWater-p.next, 0)
+	}
+	return lag
+}
@@ -0,0 +1,172 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// job is a message handed to the worker pool together with the tracker of its partition.
+type job struct {
+	msg     *sarama.ConsumerMessage
+	tracker *offsetTracker
+}
+
+// workerPool processes messages concurrently while keeping messages with the same key in order:
+// every key is routed to the same worker, and each worker handles its queue sequentially.
+type workerPool struct {
+	queues []chan job
+	wg     sync.WaitGroup
+}
+
+// newWorkerPool starts size workers with a queue of the given depth each. Every worker passes its jobs
+// to handle in batches of up to batchSize, in queue order. A batch is handed over once it is full or
+// linger has passed since its first job was taken from the queue.
+func newWorkerPool(size, depth, batchSize int, linger time.Duration, handle func([]job)) *workerPool {
+	size = max(size, 1)
+	p := &workerPool{queues: make([]chan job, size)}
+
+	for i := range p.queues {
+		queue := make(chan job, max(depth, 0))
+		p.queues[i] = queue
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for j := range queue {
+				batch, open := collectBatch(queue, j, max(batchSize, 1), linger)
+				handle(batch)
+				if !open {
+					return
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// collectBatch takes further jobs from queue until the batch started by first is full or linger
+// has passed. It reports false if the queue was closed in the meantime.
+func collectBatch(queue <-chan job, first job, batchSize int, linger time.Duration) ([]job, bool) {
+	batch := []job{first}
+	if batchSize == 1 {
+		return batch, true
+	}
+
+	timer := time.NewTimer(linger)
+	defer timer.Stop()
+
+	for len(batch) < batchSize {
+		select {
+		case j, ok := <-queue:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, j)
+		case <-timer.C:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// submit queues j on the worker that owns its key, blocking while that worker's queue is full.
+// It returns false if ctx is cancelled before the job could be queued.
+func (p *workerPool) submit(ctx context.Context, j job) bool {
+	queue := p.queues[p.route(j.msg)]
+	select {
+	case queue <- j:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// route picks the worker for a message by hashing its key, or the order UID in its payload
+// for messages produced without a key. Messages with neither are spread by offset.
+func (p *workerPool) route(msg *sarama.ConsumerMessage) int {
+	key := msg.Key
+	if len(key) == 0 {
+		var payload struct {
+			OrderUID string `json:"order_uid"`
+		}
+		if err := json.Unmarshal(msg.Value, &payload); err == nil && payload.OrderUID != "" {
+			key = []byte(payload.OrderUID)
+		}
+	}
+	if len(key) == 0 {
+		return int(uint64(msg.Offset) % uint64(len(p.queues)))
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// stop closes the queues and waits for the workers to finish the jobs already queued.
+func (p *workerPool) stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// offsetTracker tracks the messages of one partition that are being processed out of order.
+// It only lets the committed offset advance over a contiguous range of completed messages,
+// so a message that is still in flight, or was abandoned, is never skipped by a commit.
+type offsetTracker struct {
+	mu       sync.Mutex
+	pending  []trackedOffset
+	inFlight sync.WaitGroup
+}
+
+type trackedOffset struct {
+	offset int64
+	done   bool
+}
+
+// add registers a message that is about to be processed. Offsets must be added in increasing order.
+func (t *offsetTracker) add(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending = append(t.pending, trackedOffset{offset: offset})
+	t.inFlight.Add(1)
+}
+
+// complete finishes a message. done is false if the message was abandoned without being handled.
+// When the completed messages at the head of the partition form a contiguous range, complete
+// returns the offset to commit next, i.e. one past the last message of that range.
+func (t *offsetTracker) complete(offset int64, done bool) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.inFlight.Done()
+
+	for i := range t.pending {
+		if t.pending[i].offset == offset {
+			t.pending[i].done = done
+			break
+		}
+	}
+
+	advanced := 0
+	for advanced < len(t.pending) && t.pending[advanced].done {
+		advanced++
+	}
+	if advanced == 0 {
+		return 0, false
+	}
+
+	next := t.pending[advanced-1].offset + 1
+	t.pending = t.pending[advanced:]
+	return next, true
+}
+
+// wait blocks until every added message has been completed.
+func (t *offsetTracker) wait() {
+	t.inFlight.Wait()
+}
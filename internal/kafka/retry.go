@@ -0,0 +1,62 @@
+package kafka
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/models"
+	"wildberries-tech/internal/repository"
+)
+
+// backoff computes exponentially growing delays between retry attempts.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+// delay returns the wait before the given retry attempt (starting at 1). The exponential
+// delay is capped at max, and its upper half is randomized so that consumers that failed
+// together do not retry in lockstep.
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.initial
+	for i := 1; i < attempt && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// saveWithRetry saves an order and retries transient failures with exponential backoff.
+// It returns the number of attempts made along with the last error. Permanent errors are
+// returned immediately, and a cancelled ctx stops the retries with ctx.Err().
+func (c *Consumer) saveWithRetry(ctx context.Context, logger *slog.Logger, order models.Order) (int, error) {
+	maxAttempts := max(c.cfg.RetryMaxAttempts, 1)
+	policy := backoff{initial: c.cfg.RetryInitialBackoff, max: c.cfg.RetryMaxBackoff}
+
+	for attempt := 1; ; attempt++ {
+		err := c.repo.SaveOrder(ctx, order)
+		if err == nil || !repository.IsTransient(err) || attempt >= maxAttempts {
+			return attempt, err
+		}
+
+		delay := policy.delay(attempt)
+		logger.WarnContext(ctx, "Transient error saving order, retrying", logging.OrderUID(order.OrderUID),
+			"attempt", attempt, "max_attempts", maxAttempts, "retry_in", delay, logging.Err(err))
+
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
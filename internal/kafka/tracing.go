@@ -0,0 +1,130 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"wildberries-tech/internal/tracing"
+)
+
+const tracerName = "wildberries-tech/internal/kafka"
+
+// InjectTraceContext writes the trace context of ctx into the headers of msg, replacing
+// any trace context msg already carries, so that consumers continue the trace.
+func InjectTraceContext(ctx context.Context, msg *sarama.ProducerMessage) {
+	tracing.Propagator().Inject(ctx, producerHeaders{msg: msg})
+}
+
+// ExtractTraceContext returns ctx extended with the trace context carried in the headers of msg.
+func ExtractTraceContext(ctx context.Context, msg *sarama.ConsumerMessage) context.Context {
+	return tracing.Propagator().Extract(ctx, consumerHeaders(msg.Headers))
+}
+
+// TraceContext returns ctx extended with the trace context of the dead-lettered message.
+func (m DLQMessage) TraceContext(ctx context.Context) context.Context {
+	return tracing.Propagator().Extract(ctx, propagation.MapCarrier(m.Headers))
+}
+
+// StartPublishSpan starts a producer span for a message published to topic.
+func StartPublishSpan(ctx context.Context, topic string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append([]attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		semconv.MessagingOperationPublish,
+		semconv.MessagingDestinationName(topic),
+	}, attrs...)
+	return otel.Tracer(tracerName).Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attrs...))
+}
+
+// startProcessSpan starts the consumer span of msg as a child of the trace context it carries.
+func (c *Consumer) startProcessSpan(ctx context.Context, msg *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ExtractTraceContext(ctx, msg), msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingKafkaConsumerGroup(c.cfg.GroupID),
+			semconv.MessagingKafkaDestinationPartition(int(msg.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+			semconv.MessagingKafkaMessageKey(string(msg.Key)),
+		))
+}
+
+// startStep starts a span for one step of processing an order.
+func startStep(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// consumerHeaders adapts the headers of a consumed message to propagation.TextMapCarrier.
+type consumerHeaders []*sarama.RecordHeader
+
+func (h consumerHeaders) Get(key string) string {
+	for _, header := range h {
+		if header != nil && string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// Set does nothing: consumed messages are only read from.
+func (h consumerHeaders) Set(string, string) {}
+
+func (h consumerHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for _, header := range h {
+		if header != nil {
+			keys = append(keys, string(header.Key))
+		}
+	}
+	return keys
+}
+
+// producerHeaders adapts the headers of a message to be produced to propagation.TextMapCarrier.
+type producerHeaders struct {
+	msg *sarama.ProducerMessage
+}
+
+func (h producerHeaders) Get(key string) string {
+	for _, header := range h.msg.Headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (h producerHeaders) Set(key, value string) {
+	for i, header := range h.msg.Headers {
+		if string(header.Key) == key {
+			h.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	h.msg.Headers = append(h.msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (h producerHeaders) Keys() []string {
+	keys := make([]string, 0, len(h.msg.Headers))
+	for _, header := range h.msg.Headers {
+		keys = append(keys, string(header.Key))
+	}
+	return keys
+}
@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"wildberries-tech/internal/logging"
+)
+
+// recordSpans installs a global tracer provider that records spans for the duration of the test.
+func recordSpans(t *testing.T) (*tracetest.SpanRecorder, trace.Tracer) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder, provider.Tracer("test")
+}
+
+// publishedMessage returns a consumed copy of a message published in the trace of ctx.
+func publishedMessage(ctx context.Context, value []byte) *sarama.ConsumerMessage {
+	producerMsg := &sarama.ProducerMessage{Topic: "mock"}
+	InjectTraceContext(ctx, producerMsg)
+
+	msg := newMessage(value)
+	for _, header := range producerMsg.Headers {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: header.Key, Value: header.Value})
+	}
+	return msg
+}
+
+func spanNames(spans []sdktrace.ReadOnlySpan, traceID trace.TraceID) []string {
+	var names []string
+	for _, span := range spans {
+		if span.SpanContext().TraceID() == traceID {
+			names = append(names, span.Name())
+		}
+	}
+	return names
+}
+
+func TestProcessMessage_ContinuesTrace(t *testing.T) {
+	recorder, tracer := recordSpans(t)
+
+	repo := new(MockRepo)
+	cache := new(MockCache)
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(repo, cache, metricsM, testKafkaConfig(), nil, logging.Nop())
+
+	validOrder := createValidOrder()
+	validJSON, _ := json.Marshal(validOrder)
+	repo.On("SaveOrder", mock.AnythingOfType("models.Order")).Return(nil)
+	cache.On("Set", validOrder.OrderUID, mock.AnythingOfType("models.Order")).Return()
+	metricsM.On("IncMessagesTotal", "success").Return()
+
+	ctx, producerSpan := tracer.Start(context.Background(), "mock publish")
+	producerSpan.End()
+	require.NoError(t, consumer.processMessage(context.Background(), publishedMessage(ctx, validJSON)))
+
+	traceID := producerSpan.SpanContext().TraceID()
+	assert.ElementsMatch(t, []string{
+		"mock publish", "mock process", "order.unmarshal", "order.validate", "order.save", "cache.set",
+	}, spanNames(recorder.Ended(), traceID))
+
+	for _, span := range recorder.Ended() {
+		if span.Name() == "mock process" {
+			assert.Equal(t, producerSpan.SpanContext().SpanID(), span.Parent().SpanID())
+			assert.Equal(t, trace.SpanKindConsumer, span.SpanKind())
+		}
+	}
+}
+
+func TestProcessMessage_DLQCarriesTrace(t *testing.T) {
+	recorder, tracer := recordSpans(t)
+
+	metricsM := new(MockMetrics)
+	consumer := NewConsumer(new(MockRepo), new(MockCache), metricsM, testKafkaConfig(), nil, logging.Nop())
+	metricsM.On("IncMessagesTotal", "error").Return()
+
+	ctx, producerSpan := tracer.Start(context.Background(), "mock publish")
+	producerSpan.End()
+	traceID := producerSpan.SpanContext().TraceID()
+
+	dlqProducer := mocks.NewSyncProducer(t, nil)
+	dlqProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		sc := trace.SpanContextFromContext(tracingContext(msg.Headers))
+		assert.Equal(t, traceID, sc.TraceID(), "the DLQ record continues the trace")
+		return nil
+	})
+	consumer.dlqProducer = dlqProducer
+
+	require.NoError(t, consumer.processMessage(context.Background(), publishedMessage(ctx, []byte(`{invalid-json}`))))
+
+	assert.ElementsMatch(t, []string{
+		"mock publish", "mock process", "order.unmarshal", "dlq-mock publish",
+	}, spanNames(recorder.Ended(), traceID))
+}
+
+func TestInjectTraceContext_ReplacesHeader(t *testing.T) {
+	_, tracer := recordSpans(t)
+
+	msg := &sarama.ProducerMessage{Topic: "mock"}
+	first, span := tracer.Start(context.Background(), "first")
+	span.End()
+	second, span := tracer.Start(context.Background(), "second")
+	span.End()
+
+	InjectTraceContext(first, msg)
+	InjectTraceContext(second, msg)
+
+	require.Len(t, msg.Headers, 1)
+	assert.Equal(t, trace.SpanContextFromContext(second).TraceID(),
+		trace.SpanContextFromContext(tracingContext(msg.Headers)).TraceID())
+}
+
+// tracingContext extracts the trace context from the headers of a message to be produced.
+func tracingContext(headers []sarama.RecordHeader) context.Context {
+	msg := &sarama.ConsumerMessage{}
+	for _, header := range headers {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: header.Key, Value: header.Value})
+	}
+	return ExtractTraceContext(context.Background(), msg)
+}
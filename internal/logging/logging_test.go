@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"wildberries-tech/internal/config"
+)
+
+func TestNew_JSONAddsContextIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(config.LoggingConfig{Level: "info", Format: FormatJSON}, &buf)
+	require.NoError(t, err)
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "op")
+	defer span.End()
+	ctx = WithRequestID(ctx, "req-1")
+
+	logger.With(KeyTopic, "orders").InfoContext(ctx, "Order saved", OrderUID("uid-1"), Err(errors.New("boom")))
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "Order saved", record["msg"])
+	assert.Equal(t, "orders", record[KeyTopic])
+	assert.Equal(t, "uid-1", record[KeyOrderUID])
+	assert.Equal(t, "boom", record[KeyError])
+	assert.Equal(t, "req-1", record[KeyRequestID])
+	assert.Equal(t, span.SpanContext().TraceID().String(), record[KeyTraceID])
+	assert.Equal(t, span.SpanContext().SpanID().String(), record[KeySpanID])
+}
+
+func TestNew_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(config.LoggingConfig{Level: "warn", Format: FormatText}, &buf)
+	require.NoError(t, err)
+
+	logger.Info("dropped")
+	assert.Empty(t, buf.String())
+	logger.Warn("kept")
+	assert.Contains(t, buf.String(), "msg=kept")
+	assert.NotContains(t, buf.String(), KeyTraceID, "records without a span carry no trace ID")
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	_, err := New(config.LoggingConfig{Level: "loud", Format: FormatJSON}, &bytes.Buffer{})
+	assert.Error(t, err)
+	_, err = New(config.LoggingConfig{Level: "info", Format: "xml"}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
@@ -16,4 +16,27 @@ type Metrics interface {
 
 	// ObserveHTTPDuration records the duration of an HTTP request.
 	ObserveHTTPDuration(method, path string, seconds float64)
+
+	// ObserveHTTPResponseSize records the size of an HTTP response body in bytes.
+	ObserveHTTPResponseSize(method, path string, bytes int)
+
+	// IncHTTPInFlight and DecHTTPInFlight track the number of HTTP requests being served.
+	IncHTTPInFlight()
+	DecHTTPInFlight()
+
+	// IncCacheHits and IncCacheMisses count order lookups served from the cache
+	// and lookups that had to go to the database.
+	IncCacheHits()
+	IncCacheMisses()
+
+	// IncCacheSets counts orders written to the cache.
+	IncCacheSets()
+
+	// IncCacheEvictions counts orders dropped to keep the cache within its size limits,
+	// IncCacheExpirations orders dropped because their TTL passed.
+	IncCacheEvictions()
+	IncCacheExpirations()
+
+	// SetCacheSize sets the number of orders currently cached.
+	SetCacheSize(entries int)
 }
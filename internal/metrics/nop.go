@@ -0,0 +1,44 @@
+package metrics
+
+// Nop is a Metrics implementation that discards everything. It is used by tools
+// that share code with the service but do not export metrics.
+type Nop struct{}
+
+// IncMessagesTotal does nothing.
+func (Nop) IncMessagesTotal(string) {}
+
+// SetResourceUp does nothing.
+func (Nop) SetResourceUp(string, float64) {}
+
+// IncHTTPRequests does nothing.
+func (Nop) IncHTTPRequests(string, string, string) {}
+
+// ObserveHTTPDuration does nothing.
+func (Nop) ObserveHTTPDuration(string, string, float64) {}
+
+// ObserveHTTPResponseSize does nothing.
+func (Nop) ObserveHTTPResponseSize(string, string, int) {}
+
+// IncHTTPInFlight does nothing.
+func (Nop) IncHTTPInFlight() {}
+
+// DecHTTPInFlight does nothing.
+func (Nop) DecHTTPInFlight() {}
+
+// IncCacheHits does nothing.
+func (Nop) IncCacheHits() {}
+
+// IncCacheMisses does nothing.
+func (Nop) IncCacheMisses() {}
+
+// IncCacheSets does nothing.
+func (Nop) IncCacheSets() {}
+
+// IncCacheEvictions does nothing.
+func (Nop) IncCacheEvictions() {}
+
+// IncCacheExpirations does nothing.
+func (Nop) IncCacheExpirations() {}
+
+// SetCacheSize does nothing.
+func (Nop) SetCacheSize(int) {}
@@ -7,10 +7,16 @@ import (
 
 // PrometheusMetrics implements Metrics interface using Prometheus.
 type PrometheusMetrics struct {
-	messagesTotal   *prometheus.CounterVec
-	resourceUp      *prometheus.GaugeVec
-	httpRequests    *prometheus.CounterVec
-	httpDuration    *prometheus.HistogramVec
+	messagesTotal *prometheus.CounterVec
+	resourceUp    *prometheus.GaugeVec
+	httpRequests  *prometheus.CounterVec
+	httpDuration  *prometheus.HistogramVec
+	httpSize      *prometheus.HistogramVec
+	httpInFlight  prometheus.Gauge
+	cacheRequests *prometheus.CounterVec
+	cacheSets     prometheus.Counter
+	cacheRemovals *prometheus.CounterVec
+	cacheSize     prometheus.Gauge
 }
 
 // NewPrometheus creates a new PrometheusMetrics instance with all metrics registered.
@@ -45,6 +51,46 @@ func NewPrometheus() *PrometheusMetrics {
 			},
 			[]string{"method", "path"},
 		),
+		httpSize: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_response_size_bytes",
+				Help:    "HTTP response body size in bytes",
+				Buckets: prometheus.ExponentialBuckets(100, 4, 8),
+			},
+			[]string{"method", "path"},
+		),
+		httpInFlight: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "http_requests_in_flight",
+				Help: "Number of HTTP requests currently being served",
+			},
+		),
+		cacheRequests: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "cache_requests_total",
+				Help: "Total number of order cache lookups by result (hit or miss)",
+			},
+			[]string{"result"},
+		),
+		cacheSets: promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "cache_sets_total",
+				Help: "Total number of orders written to the cache",
+			},
+		),
+		cacheRemovals: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "cache_removals_total",
+				Help: "Total number of orders removed from the cache by reason (eviction or expiration)",
+			},
+			[]string{"reason"},
+		),
+		cacheSize: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "cache_entries",
+				Help: "Number of orders currently cached",
+			},
+		),
 	}
 }
 
@@ -67,3 +113,48 @@ func (p *PrometheusMetrics) IncHTTPRequests(method, path, status string) {
 func (p *PrometheusMetrics) ObserveHTTPDuration(method, path string, seconds float64) {
 	p.httpDuration.WithLabelValues(method, path).Observe(seconds)
 }
+
+// ObserveHTTPResponseSize records HTTP response body size.
+func (p *PrometheusMetrics) ObserveHTTPResponseSize(method, path string, bytes int) {
+	p.httpSize.WithLabelValues(method, path).Observe(float64(bytes))
+}
+
+// IncHTTPInFlight increments the in-flight HTTP requests gauge.
+func (p *PrometheusMetrics) IncHTTPInFlight() {
+	p.httpInFlight.Inc()
+}
+
+// DecHTTPInFlight decrements the in-flight HTTP requests gauge.
+func (p *PrometheusMetrics) DecHTTPInFlight() {
+	p.httpInFlight.Dec()
+}
+
+// IncCacheHits increments the cache lookups counter for hits.
+func (p *PrometheusMetrics) IncCacheHits() {
+	p.cacheRequests.WithLabelValues("hit").Inc()
+}
+
+// IncCacheMisses increments the cache lookups counter for misses.
+func (p *PrometheusMetrics) IncCacheMisses() {
+	p.cacheRequests.WithLabelValues("miss").Inc()
+}
+
+// IncCacheSets increments the cache writes counter.
+func (p *PrometheusMetrics) IncCacheSets() {
+	p.cacheSets.Inc()
+}
+
+// IncCacheEvictions increments the cache removals counter for evictions.
+func (p *PrometheusMetrics) IncCacheEvictions() {
+	p.cacheRemovals.WithLabelValues("eviction").Inc()
+}
+
+// IncCacheExpirations increments the cache removals counter for expirations.
+func (p *PrometheusMetrics) IncCacheExpirations() {
+	p.cacheRemovals.WithLabelValues("expiration").Inc()
+}
+
+// SetCacheSize sets the cache size gauge.
+func (p *PrometheusMetrics) SetCacheSize(entries int) {
+	p.cacheSize.Set(float64(entries))
+}
@@ -0,0 +1,177 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var testMigrations = fstest.MapFS{
+	"000002_add_index.up.sql":    {Data: []byte("CREATE INDEX idx ON orders(customer_id);")},
+	"000002_add_index.down.sql":  {Data: []byte("DROP INDEX idx;")},
+	"000001_init.up.sql":         {Data: []byte("CREATE TABLE orders (order_uid TEXT);")},
+	"000001_init.down.sql":       {Data: []byte("DROP TABLE orders;")},
+	"migrations.go":              {Data: []byte("package migrations")},
+	"README.md":                  {Data: []byte("ignored")},
+	"000003_no_down_file.up.sql": {Data: []byte("ALTER TABLE orders ADD COLUMN note TEXT;")},
+}
+
+func newTestMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	migrator, err := New(db, testMigrations)
+	require.NoError(t, err)
+	return migrator, mock
+}
+
+func expectVersion(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, dirty FROM schema_migrations")).WillReturnRows(rows)
+}
+
+func expectSetVersion(mock sqlmock.Sqlmock, version uint64, dirty bool) {
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schema_migrations")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations")).
+		WithArgs(version, dirty).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := load(testMigrations)
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+
+	require.Equal(t, uint64(1), migrations[0].Version)
+	require.Equal(t, "init", migrations[0].Name)
+	require.Equal(t, "DROP TABLE orders;", migrations[0].Down)
+	require.Equal(t, uint64(3), migrations[2].Version)
+	require.Empty(t, migrations[2].Down)
+
+	_, err = load(fstest.MapFS{"000001_init.down.sql": {Data: []byte("DROP TABLE orders;")}})
+	require.ErrorContains(t, err, "has no up file")
+}
+
+func TestUp(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVersion(mock, sqlmock.NewRows([]string{"version", "dirty"}).AddRow(1, false))
+	for _, m := range []struct {
+		version uint64
+		sql     string
+	}{
+		{2, "CREATE INDEX idx ON orders(customer_id);"},
+		{3, "ALTER TABLE orders ADD COLUMN note TEXT;"},
+	} {
+		mock.ExpectBegin()
+		expectSetVersion(mock, m.version, true)
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(m.sql)).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectSetVersion(mock, m.version, false)
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := migrator.Up(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, applied)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_FailedMigrationRestoresVersion(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVersion(mock, sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, false))
+	mock.ExpectBegin()
+	expectSetVersion(mock, 3, true)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE orders")).WillReturnError(errors.New("column already exists"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectSetVersion(mock, 2, false)
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := migrator.Up(context.Background())
+	require.ErrorContains(t, err, "migration 3_no_down_file failed: column already exists")
+	require.Zero(t, applied)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDown(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVersion(mock, sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, false))
+	mock.ExpectBegin()
+	expectSetVersion(mock, 2, true)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DROP INDEX idx;")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectSetVersion(mock, 1, false)
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reverted, err := migrator.Down(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, reverted)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_RefusesDirtySchema(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVersion(mock, sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, true))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, err := migrator.Up(context.Background())
+	require.ErrorIs(t, err, ErrDirty)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheck(t *testing.T) {
+	tests := map[string]struct {
+		rows *sqlmock.Rows
+		err  error
+	}{
+		"empty":    {sqlmock.NewRows([]string{"version", "dirty"}), ErrOutdated},
+		"outdated": {sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, false), ErrOutdated},
+		"dirty":    {sqlmock.NewRows([]string{"version", "dirty"}).AddRow(3, true), ErrDirty},
+		"current":  {sqlmock.NewRows([]string{"version", "dirty"}).AddRow(3, false), nil},
+		"newer":    {sqlmock.NewRows([]string{"version", "dirty"}).AddRow(4, false), nil},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			migrator, mock := newTestMigrator(t)
+			expectVersion(mock, tt.rows)
+
+			err := migrator.Check(context.Background())
+			if tt.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tt.err)
+			}
+
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
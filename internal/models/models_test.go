@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validOrder(uid string) Order {
+	return Order{
+		OrderUID:    uid,
+		TrackNumber: "TRACK123",
+		Entry:       "WBIL",
+		Delivery: Delivery{
+			Name: "Test User", Phone: "+1234567890", Zip: "123456", City: "Test City",
+			Address: "Test Address", Region: "Test Region", Email: "test@example.com",
+		},
+		Payment: Payment{
+			Transaction: "trans-123", Currency: "USD", Provider: "wbpay", Amount: 100,
+			PaymentDt: 1620000000, Bank: "alpha", DeliveryCost: 1500, GoodsTotal: 100,
+		},
+		Items: []Item{{
+			ChrtID: 123456, TrackNumber: "TRACK123", Price: 100, Rid: "rid-1", Name: "Item 1",
+			Size: "M", TotalPrice: 100, NmID: 1234567, Brand: "TestBrand", Status: 202,
+		}},
+		Locale:          "en",
+		CustomerID:      "cust-1",
+		DeliveryService: "meest",
+		Shardkey:        "9",
+		SmID:            99,
+		DateCreated:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		OofShard:        "1",
+	}
+}
+
+func TestOrderValidate_OrderUID(t *testing.T) {
+	order := validOrder("b563feb7b2b84b6test")
+	require.NoError(t, order.Validate())
+
+	order.OrderUID = "order.1/2024"
+	assert.NoError(t, order.Validate(), "only the request paths of the HTTP API restrict the UID format")
+
+	order.OrderUID = ""
+	err := order.Validate()
+	var verrs validator.ValidationErrors
+	require.True(t, errors.As(err, &verrs))
+	require.Len(t, verrs, 1)
+	assert.Equal(t, "Order.OrderUID", verrs[0].Namespace())
+}
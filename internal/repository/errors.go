@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// transientSQLStates lists PostgreSQL error codes that describe a temporary condition.
+// Retrying the same statement later can succeed without any change to the data.
+var transientSQLStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"57014": true, // query_canceled (statement timeout)
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"53300": true, // too_many_connections
+}
+
+// IsNotFound reports whether err means that the requested record does not exist.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrOrderNotFound) || errors.Is(err, gorm.ErrRecordNotFound)
+}
+
+// IsTransient reports whether err is a temporary database failure such as a lost connection,
+// a timeout or a serialization failure. Such operations may succeed when retried.
+// All other errors, including ErrOrderConflict, are permanent.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 covers all connection exceptions.
+		return strings.HasPrefix(pgErr.Code, "08") || transientSQLStates[pgErr.Code]
+	}
+
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &connectErr), errors.As(err, &netErr):
+		return true
+	case pgconn.Timeout(err), pgconn.SafeToRetry(err):
+		return true
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	return false
+}
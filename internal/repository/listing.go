@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"wildberries-tech/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCursor is returned by DecodeCursor for a token that was not produced by Cursor.Encode.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// OrderFilter narrows down ListOrders. Zero-valued fields match every order.
+type OrderFilter struct {
+	CustomerID      string
+	DeliveryService string
+	PaymentProvider string
+	PaymentCurrency string
+	Locale          string
+	// CreatedFrom and CreatedTo bound date_created to the half-open range [CreatedFrom, CreatedTo).
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+// Cursor is the position of the last order of a page. Orders are listed newest first,
+// by date_created and then by order_uid, so the pair identifies a position uniquely.
+type Cursor struct {
+	DateCreated time.Time
+	OrderUID    string
+}
+
+// OrderPage is a page of orders returned by ListOrders. Next is nil on the last page.
+type OrderPage struct {
+	Orders []models.Order
+	Next   *Cursor
+}
+
+// Encode returns the cursor as an opaque URL-safe token.
+func (c Cursor) Encode() string {
+	raw := c.DateCreated.UTC().Format(time.RFC3339Nano) + "|" + c.OrderUID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a token produced by Cursor.Encode.
+func DecodeCursor(token string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	date, uid, found := strings.Cut(string(raw), "|")
+	if !found || uid == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{DateCreated: t, OrderUID: uid}, nil
+}
+
+// ListOrders returns up to limit orders matching filter, newest first, starting after the given cursor
+// or from the newest order if after is nil.
+func (r *Repository) ListOrders(ctx context.Context, filter OrderFilter, after *Cursor, limit int) (OrderPage, error) {
+	query := applyOrderFilter(r.db.WithContext(ctx).Model(&models.Order{}), filter)
+	if after != nil {
+		query = query.Where("(date_created, order_uid) < (?, ?)", after.DateCreated, after.OrderUID)
+	}
+
+	var orders []models.Order
+	// One extra row tells whether there is a next page.
+	err := query.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Order("date_created DESC").Order("order_uid DESC").
+		Limit(limit + 1).
+		Find(&orders).Error
+	if err != nil {
+		return OrderPage{}, fmt.Errorf("failed to list orders: %w", err)
+	}
+
+	page := OrderPage{Orders: orders}
+	if len(orders) > limit {
+		page.Orders = orders[:limit]
+		last := page.Orders[limit-1]
+		page.Next = &Cursor{DateCreated: last.DateCreated, OrderUID: last.OrderUID}
+	}
+	return page, nil
+}
+
+func applyOrderFilter(query *gorm.DB, filter OrderFilter) *gorm.DB {
+	conditions := []struct {
+		column string
+		value  string
+	}{
+		{"customer_id", filter.CustomerID},
+		{"delivery_service", filter.DeliveryService},
+		{"payment_provider", filter.PaymentProvider},
+		{"payment_currency", filter.PaymentCurrency},
+		{"locale", filter.Locale},
+	}
+	for _, c := range conditions {
+		if c.value != "" {
+			query = query.Where(c.column+" = ?", c.value)
+		}
+	}
+
+	// date_created is a TIMESTAMP holding UTC wall-clock times, and the driver drops the
+	// offset of bound times, so bounds given in another zone are converted first.
+	if !filter.CreatedFrom.IsZero() {
+		query = query.Where("date_created >= ?", filter.CreatedFrom.UTC())
+	}
+	if !filter.CreatedTo.IsZero() {
+		query = query.Where("date_created < ?", filter.CreatedTo.UTC())
+	}
+	return query
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"wildberries-tech/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// maxLookupResults bounds the number of orders returned by a lookup. Identifiers such as track numbers
+// are expected to match a handful of orders; anything beyond the bound is better browsed with ListOrders.
+const maxLookupResults = 100
+
+// FindOrdersByTrackNumber returns the orders whose own track number or the track number
+// of one of their items equals trackNumber, newest first.
+func (r *Repository) FindOrdersByTrackNumber(ctx context.Context, trackNumber string) ([]models.Order, error) {
+	items := r.db.Model(&models.Item{}).Select("order_uid").Where("track_number = ?", trackNumber)
+	return r.findOrders(ctx, "track number "+trackNumber,
+		r.db.Where("track_number = ?", trackNumber).Or("order_uid IN (?)", items))
+}
+
+// FindOrdersByTransaction returns the orders paid with the given payment transaction, newest first.
+func (r *Repository) FindOrdersByTransaction(ctx context.Context, transaction string) ([]models.Order, error) {
+	return r.findOrders(ctx, "transaction "+transaction, r.db.Where("payment_transaction = ?", transaction))
+}
+
+// FindOrdersByRID returns the orders containing an item with the given rid, newest first.
+func (r *Repository) FindOrdersByRID(ctx context.Context, rid string) ([]models.Order, error) {
+	items := r.db.Model(&models.Item{}).Select("order_uid").Where("rid = ?", rid)
+	return r.findOrders(ctx, "rid "+rid, r.db.Where("order_uid IN (?)", items))
+}
+
+// findOrders loads the orders matching condition together with their items.
+// The description names the lookup in errors.
+func (r *Repository) findOrders(ctx context.Context, description string, condition *gorm.DB) ([]models.Order, error) {
+	var orders []models.Order
+
+	err := r.db.WithContext(ctx).
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where(condition).
+		Order("date_created DESC").Order("order_uid DESC").
+		Limit(maxLookupResults).
+		Find(&orders).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find orders by %s: %w", description, err)
+	}
+
+	return orders, nil
+}
@@ -2,45 +2,100 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
+	"reflect"
 	"time"
 	"wildberries-tech/internal/config"
+	"wildberries-tech/internal/logging"
 	"wildberries-tech/internal/models"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	gormlogger "gorm.io/gorm/logger"
 )
 
+// Conflict policies for orders that are saved again with a different payload.
+const (
+	ConflictReject = "reject"
+	ConflictUpdate = "update"
+)
+
+var (
+	// ErrDuplicateOrder is returned by SaveOrder when an identical order is already stored.
+	// Nothing is written, so callers can treat it as a successful no-op.
+	ErrDuplicateOrder = errors.New("order already exists")
+	// ErrOrderConflict is returned by SaveOrder when an order with the same UID but a
+	// different payload is already stored and the conflict policy is ConflictReject.
+	ErrOrderConflict = errors.New("order already exists with a different payload")
+	// ErrBatchConflict is returned by SaveOrders when an order of the batch is already stored or
+	// appears in the batch more than once. Nothing is written; the orders have to be saved one by one.
+	ErrBatchConflict = errors.New("batch contains orders that are already stored")
+	// ErrOrderNotFound is returned by GetOrder when no order has the requested UID.
+	ErrOrderNotFound = errors.New("order not found")
+)
+
+// slowQueryThreshold is the duration above which a statement is logged as slow.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// insertBatchSize is the number of rows per INSERT statement. It keeps the statement below
+// the PostgreSQL limit of 65535 bind parameters.
+const insertBatchSize = 500
+
 // OrderRepository defines the interface for database interactions.
 type OrderRepository interface {
-	SaveOrder(order models.Order) error
-	GetOrder(orderUID string) (*models.Order, error)
-	GetAllOrders() ([]models.Order, error)
+	SaveOrder(ctx context.Context, order models.Order) error
+	SaveOrders(ctx context.Context, orders []models.Order) error
+	GetOrder(ctx context.Context, orderUID string) (*models.Order, error)
+	ListOrders(ctx context.Context, filter OrderFilter, after *Cursor, limit int) (OrderPage, error)
+	FindOrdersByTrackNumber(ctx context.Context, trackNumber string) ([]models.Order, error)
+	FindOrdersByTransaction(ctx context.Context, transaction string) ([]models.Order, error)
+	FindOrdersByRID(ctx context.Context, rid string) ([]models.Order, error)
 	Close() error
 	DB() (*sql.DB, error)
 }
 
 // Repository implements OrderRepository using GORM.
 type Repository struct {
-	db *gorm.DB
+	db             *gorm.DB
+	conflictPolicy string
 }
 
-// New creates a new Repository with retry logic for database connection.
-func New(cfg config.DatabaseConfig) (*Repository, error) {
+// New creates a new Repository with retry logic for database connection. Failed and slow
+// statements are logged to logger, with placeholders instead of the values of their parameters.
+func New(cfg config.DatabaseConfig, logger *slog.Logger) (*Repository, error) {
+	switch cfg.ConflictPolicy {
+	case ConflictReject, ConflictUpdate:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %q", cfg.ConflictPolicy)
+	}
+
+	gormConfig := &gorm.Config{
+		Logger: gormlogger.NewSlogLogger(logger, gormlogger.Config{
+			SlowThreshold:             slowQueryThreshold,
+			IgnoreRecordNotFoundError: true,
+			ParameterizedQueries:      true,
+			LogLevel:                  gormlogger.Warn,
+		}),
+	}
+
 	var db *gorm.DB
 	var err error
 
 	for attempt := 1; attempt <= cfg.MaxRetries; attempt++ {
-		db, err = gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{})
+		db, err = gorm.Open(postgres.Open(cfg.DSN()), gormConfig)
 		if err == nil {
 			// Connection successful, break the retry loop
 			break
 		}
 
 		if attempt < cfg.MaxRetries {
-			fmt.Printf("Failed to connect to database (attempt %d/%d): %v. Retrying in %v...\n",
-				attempt, cfg.MaxRetries, err, cfg.RetryDelay)
+			logger.Warn("Failed to connect to database, retrying",
+				"attempt", attempt, "max_attempts", cfg.MaxRetries, "retry_in", cfg.RetryDelay, logging.Err(err))
 			time.Sleep(cfg.RetryDelay)
 		}
 	}
@@ -49,45 +104,165 @@ func New(cfg config.DatabaseConfig) (*Repository, error) {
 		return nil, fmt.Errorf("failed to connect to database after %d attempts: %w", cfg.MaxRetries, err)
 	}
 
-	if err := db.AutoMigrate(&models.Order{}, &models.Item{}); err != nil {
-		return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+	if err := registerTracing(db); err != nil {
+		return nil, err
 	}
 
-	return &Repository{db: db}, nil
+	return &Repository{db: db, conflictPolicy: cfg.ConflictPolicy}, nil
 }
 
 // SaveOrder persists an order and its nested items to the database within an explicit transaction.
-func (r *Repository) SaveOrder(order models.Order) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Create(&order).Error; err != nil {
-			return fmt.Errorf("failed to create order: %w", err)
+// Saving is idempotent: if the order is already stored with the same payload, ErrDuplicateOrder is
+// returned and nothing changes. A different payload for an existing order is either rejected with
+// ErrOrderConflict or replaces the stored order and its items, depending on the conflict policy.
+func (r *Repository) SaveOrder(ctx context.Context, order models.Order) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&order)
+		if result.Error != nil {
+			return fmt.Errorf("failed to create order: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return r.resolveConflict(tx, order)
+		}
+		return createItems(tx, order)
+	})
+}
+
+// SaveOrders inserts a batch of new orders and their items in a single transaction using
+// multi-row inserts. The batch is all or nothing, and unlike SaveOrder it does not resolve
+// duplicates: if any order is already stored, ErrBatchConflict is returned.
+func (r *Repository) SaveOrders(ctx context.Context, orders []models.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	uids := make([]string, 0, len(orders))
+	seen := make(map[string]bool, len(orders))
+	for _, order := range orders {
+		if seen[order.OrderUID] {
+			return fmt.Errorf("%w: order %s appears twice", ErrBatchConflict, order.OrderUID)
+		}
+		seen[order.OrderUID] = true
+		uids = append(uids, order.OrderUID)
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&models.Order{}).Where("order_uid IN ?", uids).Count(&existing).Error; err != nil {
+			return fmt.Errorf("failed to check for existing orders: %w", err)
+		}
+		if existing > 0 {
+			return fmt.Errorf("%w: %d of %d orders", ErrBatchConflict, existing, len(orders))
+		}
+
+		batch := make([]models.Order, len(orders))
+		var items []models.Item
+		for i, order := range orders {
+			for _, item := range order.Items {
+				item.ID = 0
+				item.OrderUID = order.OrderUID
+				items = append(items, item)
+			}
+			batch[i] = order
+		}
+
+		if err := tx.Omit(clause.Associations).CreateInBatches(&batch, insertBatchSize).Error; err != nil {
+			return fmt.Errorf("failed to create orders: %w", err)
+		}
+		if len(items) > 0 {
+			if err := tx.CreateInBatches(&items, insertBatchSize).Error; err != nil {
+				return fmt.Errorf("failed to create items: %w", err)
+			}
 		}
 		return nil
 	})
 }
 
-// GetOrder retrieves a single order by its UID.
-func (r *Repository) GetOrder(orderUID string) (*models.Order, error) {
-	var order models.Order
+// resolveConflict handles an order whose UID is already stored. It runs inside the SaveOrder transaction
+// and locks the stored row, so concurrent saves of the same order are serialized.
+func (r *Repository) resolveConflict(tx *gorm.DB, order models.Order) error {
+	var existing models.Order
 
-	result := r.db.Preload("Items").Where("order_uid = ?", orderUID).First(&order)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to get order %s: %w", orderUID, result.Error)
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("order_uid = ?", order.OrderUID).
+		First(&existing).Error
+	if err != nil {
+		return fmt.Errorf("failed to load existing order %s: %w", order.OrderUID, err)
 	}
 
-	return &order, nil
+	if sameOrder(existing, order) {
+		return ErrDuplicateOrder
+	}
+	if r.conflictPolicy != ConflictUpdate {
+		return fmt.Errorf("%w: %s", ErrOrderConflict, order.OrderUID)
+	}
+
+	if err := tx.Omit(clause.Associations).Save(&order).Error; err != nil {
+		return fmt.Errorf("failed to update order: %w", err)
+	}
+	if err := tx.Where("order_uid = ?", order.OrderUID).Delete(&models.Item{}).Error; err != nil {
+		return fmt.Errorf("failed to delete items of order %s: %w", order.OrderUID, err)
+	}
+	return createItems(tx, order)
 }
 
-// GetAllOrders retrieves all orders from the database.
-func (r *Repository) GetAllOrders() ([]models.Order, error) {
-	var orders []models.Order
+// createItems inserts the items of an order, linking them to the order and letting the database assign IDs.
+func createItems(tx *gorm.DB, order models.Order) error {
+	if len(order.Items) == 0 {
+		return nil
+	}
 
-	result := r.db.Preload("Items").Find(&orders)
+	items := make([]models.Item, len(order.Items))
+	for i, item := range order.Items {
+		item.ID = 0
+		item.OrderUID = order.OrderUID
+		items[i] = item
+	}
+
+	if err := tx.Create(&items).Error; err != nil {
+		return fmt.Errorf("failed to create items: %w", err)
+	}
+	return nil
+}
+
+// sameOrder reports whether two orders carry the same payload, ignoring database-assigned fields.
+func sameOrder(a, b models.Order) bool {
+	return reflect.DeepEqual(normalizeOrder(a), normalizeOrder(b))
+}
+
+// normalizeOrder strips the fields that differ between a decoded message and its stored copy:
+// item IDs and back references, and the time zone and sub-microsecond precision that a
+// PostgreSQL TIMESTAMP column does not keep.
+func normalizeOrder(order models.Order) models.Order {
+	t := order.DateCreated
+	order.DateCreated = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(),
+		t.Nanosecond(), time.UTC).Truncate(time.Microsecond)
+
+	items := make([]models.Item, len(order.Items))
+	for i, item := range order.Items {
+		item.ID = 0
+		item.OrderUID = ""
+		items[i] = item
+	}
+	order.Items = items
+
+	return order
+}
+
+// GetOrder retrieves a single order by its UID.
+func (r *Repository) GetOrder(ctx context.Context, orderUID string) (*models.Order, error) {
+	var order models.Order
+
+	result := r.db.WithContext(ctx).Preload("Items").Where("order_uid = ?", orderUID).First(&order)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrOrderNotFound, orderUID)
+	}
 	if result.Error != nil {
-		return nil, fmt.Errorf("failed to get all orders: %w", result.Error)
+		return nil, fmt.Errorf("failed to get order %s: %w", orderUID, result.Error)
 	}
 
-	return orders, nil
+	return &order, nil
 }
 
 // Close closes the underlying database connection.
@@ -1,6 +1,11 @@
 package repository
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
 	"regexp"
 	"testing"
 	"time"
@@ -8,7 +13,11 @@ import (
 	"wildberries-tech/internal/models"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -54,7 +63,7 @@ func TestSaveOrder(t *testing.T) {
 
 	mock.ExpectCommit()
 
-	err = repo.SaveOrder(order)
+	err = repo.SaveOrder(context.Background(), order)
 	require.NoError(t, err)
 
 	require.NoError(t, mock.ExpectationsWereMet())
@@ -82,7 +91,7 @@ func TestGetOrder(t *testing.T) {
 		WithArgs("test-uid").
 		WillReturnRows(sqlmock.NewRows([]string{"id", "order_uid", "track_number"}).AddRow(1, "test-uid", "item-track"))
 
-	order, err := repo.GetOrder("test-uid")
+	order, err := repo.GetOrder(context.Background(), "test-uid")
 	require.NoError(t, err)
 	require.NotNil(t, order)
 	require.Equal(t, "test-uid", order.OrderUID)
@@ -90,10 +99,12 @@ func TestGetOrder(t *testing.T) {
 	require.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestGetAllOrders(t *testing.T) {
+func newTestRepository(t *testing.T, conflictPolicy string) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
-	defer func() { _ = db.Close() }()
+	t.Cleanup(func() { _ = db.Close() })
 
 	dialector := postgres.New(postgres.Config{
 		Conn:       db,
@@ -102,22 +113,321 @@ func TestGetAllOrders(t *testing.T) {
 	gdb, err := gorm.Open(dialector, &gorm.Config{})
 	require.NoError(t, err)
 
-	repo := &Repository{db: gdb}
+	return &Repository{db: gdb, conflictPolicy: conflictPolicy}, mock
+}
+
+// expectExistingOrder sets up the conflicting insert and the locked lookup of the stored order.
+func expectExistingOrder(mock sqlmock.Sqlmock, order models.Order, storedTrack string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "orders"`) + `.*ON CONFLICT DO NOTHING`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT \* FROM "orders" WHERE order_uid = \$1 .* FOR UPDATE`).
+		WithArgs(order.OrderUID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "track_number", "date_created"}).
+			AddRow(order.OrderUID, storedTrack, order.DateCreated.UTC()))
+	mock.ExpectQuery(`SELECT \* FROM "items" WHERE "items"\."order_uid" = \$1 ORDER BY id`).
+		WithArgs(order.OrderUID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_uid", "chrt_id", "track_number", "price"}).
+			AddRow(7, order.OrderUID, order.Items[0].ChrtID, order.Items[0].TrackNumber, order.Items[0].Price))
+}
+
+func conflictTestOrder() models.Order {
+	return models.Order{
+		OrderUID:    "test-uid",
+		TrackNumber: "test-track",
+		DateCreated: time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC),
+		Items: []models.Item{
+			{ChrtID: 123, TrackNumber: "item-track", Price: 100},
+		},
+	}
+}
+
+func TestSaveOrder_Duplicate(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+	order := conflictTestOrder()
+
+	expectExistingOrder(mock, order, order.TrackNumber)
+	mock.ExpectRollback()
+
+	err := repo.SaveOrder(context.Background(), order)
+	require.ErrorIs(t, err, ErrDuplicateOrder)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveOrder_ConflictRejected(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+	order := conflictTestOrder()
+
+	expectExistingOrder(mock, order, "other-track")
+	mock.ExpectRollback()
+
+	err := repo.SaveOrder(context.Background(), order)
+	require.ErrorIs(t, err, ErrOrderConflict)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveOrder_ConflictUpdated(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictUpdate)
+	order := conflictTestOrder()
+
+	expectExistingOrder(mock, order, "other-track")
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "items" WHERE order_uid = $1`)).
+		WithArgs(order.OrderUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "items"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectCommit()
+
+	require.NoError(t, repo.SaveOrder(context.Background(), order))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"connection exception", fmt.Errorf("save: %w", &pgconn.PgError{Code: "08006"}), true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"timeout", context.DeadlineExceeded, true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"conflict", ErrOrderConflict, false},
+		{"not found", gorm.ErrRecordNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, IsTransient(tt.err))
+		})
+	}
+}
+
+func TestSaveOrders(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	first := conflictTestOrder()
+	second := conflictTestOrder()
+	second.OrderUID = "test-uid-2"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders" WHERE order_uid IN ($1,$2)`)).
+		WithArgs("test-uid", "test-uid-2").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "orders"`) + `.*\),\(`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "items"`) + `.*\),\(`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+
+	require.NoError(t, repo.SaveOrders(context.Background(), []models.Order{first, second}))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveOrders_ExistingOrder(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	err := repo.SaveOrders(context.Background(), []models.Order{conflictTestOrder()})
+	require.ErrorIs(t, err, ErrBatchConflict)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveOrders_DuplicateInBatch(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	err := repo.SaveOrders(context.Background(), []models.Order{conflictTestOrder(), conflictTestOrder()})
+	require.ErrorIs(t, err, ErrBatchConflict)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrder_CancelledContext(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.GetOrder(ctx, "test-uid")
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTracing_RecordsChildSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	repo, mock := newTestRepository(t, ConflictReject)
+	require.NoError(t, registerTracing(repo.db))
 
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders"`)).
-		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "track_number"}).
-			AddRow("test-uid-1", "track-1").
-			AddRow("test-uid-2", "track-2"))
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid"}).AddRow("test-uid"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "items"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_uid"}))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "GET /order/{order_uid}")
+	_, err := repo.GetOrder(ctx, "test-uid")
+	require.NoError(t, err)
+	parent.End()
+
+	var queries int
+	for _, span := range recorder.Ended() {
+		if span.Name() != "gorm.query" {
+			continue
+		}
+		queries++
+		require.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+	}
+	require.Equal(t, 2, queries, "the order query and the items preload")
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListOrders(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	newest := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	after := &Cursor{DateCreated: newest.Add(time.Hour), OrderUID: "uid-0"}
+	filter := OrderFilter{CustomerID: "customer-1", PaymentCurrency: "USD", CreatedFrom: newest.AddDate(0, 0, -7)}
 
-	mock.ExpectQuery(`SELECT \* FROM "items" WHERE "items"\."order_uid" IN \(\$1,\$2\)`).
-		WithArgs("test-uid-1", "test-uid-2").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "order_uid", "track_number"}).
-			AddRow(1, "test-uid-1", "item-1").
-			AddRow(2, "test-uid-2", "item-2"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE customer_id = $1 AND payment_currency = $2 `+
+		`AND date_created >= $3 AND (date_created, order_uid) < ($4, $5) `+
+		`ORDER BY date_created DESC,order_uid DESC LIMIT $6`)).
+		WithArgs("customer-1", "USD", filter.CreatedFrom, after.DateCreated, after.OrderUID, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "date_created"}).
+			AddRow("uid-3", newest).
+			AddRow("uid-2", newest.Add(-time.Hour)).
+			AddRow("uid-1", newest.Add(-2*time.Hour)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "items" WHERE "items"."order_uid" IN ($1,$2,$3) ORDER BY id`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_uid"}))
 
-	orders, err := repo.GetAllOrders()
+	page, err := repo.ListOrders(context.Background(), filter, after, 2)
 	require.NoError(t, err)
-	require.Len(t, orders, 2)
+	require.Len(t, page.Orders, 2)
+	require.Equal(t, "uid-3", page.Orders[0].OrderUID)
+	require.Equal(t, &Cursor{DateCreated: newest.Add(-time.Hour), OrderUID: "uid-2"}, page.Next)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// utcTime matches a time argument bound as UTC at the given instant.
+type utcTime struct{ time.Time }
+
+func (u utcTime) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Location() == time.UTC && t.Equal(u.Time)
+}
+
+func TestListOrders_BoundsInUTC(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	moscow := time.FixedZone("MSK", 3*60*60)
+	filter := OrderFilter{
+		CreatedFrom: time.Date(2024, 5, 1, 0, 0, 0, 0, moscow),
+		CreatedTo:   time.Date(2024, 5, 2, 0, 0, 0, 0, moscow),
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE date_created >= $1 AND date_created < $2 `+
+		`ORDER BY date_created DESC,order_uid DESC LIMIT $3`)).
+		WithArgs(utcTime{time.Date(2024, 4, 30, 21, 0, 0, 0, time.UTC)},
+			utcTime{time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)}, 21).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "date_created"}))
+
+	_, err := repo.ListOrders(context.Background(), filter, nil, 20)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListOrders_LastPage(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" ORDER BY date_created DESC,order_uid DESC LIMIT $1`)).
+		WithArgs(21).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "date_created"}).AddRow("uid-1", time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "items"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_uid"}))
+
+	page, err := repo.ListOrders(context.Background(), OrderFilter{}, nil, 20)
+	require.NoError(t, err)
+	require.Len(t, page.Orders, 1)
+	require.Nil(t, page.Next)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	cursor := Cursor{DateCreated: time.Date(2024, 5, 3, 12, 0, 0, 123456000, time.UTC), OrderUID: "uid|with|pipes"}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	require.NoError(t, err)
+	require.Equal(t, cursor, decoded)
+
+	for _, token := range []string{"", "!!!", "bm8tc2VwYXJhdG9y", "bm90LWEtZGF0ZXx1aWQ"} {
+		_, err := DecodeCursor(token)
+		require.ErrorIs(t, err, ErrInvalidCursor, token)
+	}
+}
+
+func TestFindOrdersByTrackNumber(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE track_number = $1 OR order_uid IN `+
+		`(SELECT "order_uid" FROM "items" WHERE track_number = $2) ORDER BY date_created DESC,order_uid DESC LIMIT $3`)).
+		WithArgs("WBILMTESTTRACK", "WBILMTESTTRACK", maxLookupResults).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid"}).AddRow("test-uid"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "items" WHERE "items"."order_uid" = $1 ORDER BY id`)).
+		WithArgs("test-uid").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_uid", "track_number"}).AddRow(1, "test-uid", "WBILMTESTTRACK"))
+
+	orders, err := repo.FindOrdersByTrackNumber(context.Background(), "WBILMTESTTRACK")
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Len(t, orders[0].Items, 1)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindOrdersByTransaction(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE payment_transaction = $1`)).
+		WithArgs("tx-1", maxLookupResults).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid"}))
+
+	orders, err := repo.FindOrdersByTransaction(context.Background(), "tx-1")
+	require.NoError(t, err)
+	require.Empty(t, orders)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindOrdersByRID(t *testing.T) {
+	repo, mock := newTestRepository(t, ConflictReject)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE order_uid IN (SELECT "order_uid" FROM "items" WHERE rid = $1)`)).
+		WithArgs("rid-1", maxLookupResults).
+		WillReturnError(errors.New("connection reset"))
+
+	_, err := repo.FindOrdersByRID(context.Background(), "rid-1")
+	require.ErrorContains(t, err, "failed to find orders by rid rid-1")
 
 	require.NoError(t, mock.ExpectationsWereMet())
 }
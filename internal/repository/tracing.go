@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const tracerName = "wildberries-tech/internal/repository"
+
+// registerTracing adds GORM callbacks that record every statement as a span. The spans are children
+// of the span carried by the statement context, so queries made with WithContext show up under the
+// HTTP request or Kafka message that caused them.
+func registerTracing(db *gorm.DB) error {
+	c := db.Callback()
+	err := errors.Join(
+		c.Create().Before("gorm:create").Register("tracing:before_create", startSpan("create")),
+		c.Create().After("gorm:create").Register("tracing:after_create", endSpan),
+		c.Query().Before("gorm:query").Register("tracing:before_query", startSpan("query")),
+		c.Query().After("gorm:query").Register("tracing:after_query", endSpan),
+		c.Update().Before("gorm:update").Register("tracing:before_update", startSpan("update")),
+		c.Update().After("gorm:update").Register("tracing:after_update", endSpan),
+		c.Delete().Before("gorm:delete").Register("tracing:before_delete", startSpan("delete")),
+		c.Delete().After("gorm:delete").Register("tracing:after_delete", endSpan),
+		c.Row().Before("gorm:row").Register("tracing:before_row", startSpan("row")),
+		c.Row().After("gorm:row").Register("tracing:after_row", endSpan),
+		c.Raw().Before("gorm:raw").Register("tracing:before_raw", startSpan("raw")),
+		c.Raw().After("gorm:raw").Register("tracing:after_raw", endSpan),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register tracing callbacks: %w", err)
+	}
+	return nil
+}
+
+func startSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, _ := otel.Tracer(tracerName).Start(db.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(operation)))
+		db.Statement.Context = ctx
+	}
+}
+
+func endSpan(db *gorm.DB) {
+	span := trace.SpanFromContext(db.Statement.Context)
+	if !span.IsRecording() {
+		return
+	}
+	defer span.End()
+
+	span.SetAttributes(
+		semconv.DBStatement(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Statement.Table != "" {
+		span.SetAttributes(semconv.DBSQLTable(db.Statement.Table))
+	}
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
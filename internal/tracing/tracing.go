@@ -4,7 +4,7 @@ package tracing
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -25,7 +25,20 @@ type Config struct {
 	Enabled        bool
 }
 
-// Tracer wraps OpenTelemetry tracer for application use.
+// propagator carries W3C trace context and baggage across process boundaries.
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// Propagator returns the propagator used to pass trace context in HTTP and Kafka headers.
+// Trace context is propagated even when tracing is disabled, so that a service without
+// tracing does not break the traces of the services around it.
+func Propagator() propagation.TextMapPropagator {
+	return propagator
+}
+
+// Tracer wraps OpenTelemetry tracer for application use. A nil *Tracer is a disabled tracer.
 type Tracer struct {
 	tracer   trace.Tracer
 	provider *sdktrace.TracerProvider
@@ -35,7 +48,7 @@ type Tracer struct {
 // New initializes OpenTelemetry tracing with the given configuration.
 func New(ctx context.Context, cfg Config) (*Tracer, error) {
 	if !cfg.Enabled {
-		log.Println("Tracing is disabled")
+		slog.Info("Tracing is disabled")
 		return &Tracer{enabled: false}, nil
 	}
 
@@ -65,12 +78,9 @@ func New(ctx context.Context, cfg Config) (*Tracer, error) {
 	)
 
 	otel.SetTracerProvider(provider)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	))
+	otel.SetTextMapPropagator(propagator)
 
-	log.Printf("Tracing initialized: service=%s, endpoint=%s", cfg.ServiceName, cfg.JaegerEndpoint)
+	slog.Info("Tracing initialized", "service", cfg.ServiceName, "endpoint", cfg.JaegerEndpoint)
 
 	return &Tracer{
 		tracer:   provider.Tracer(cfg.ServiceName),
@@ -81,7 +91,7 @@ func New(ctx context.Context, cfg Config) (*Tracer, error) {
 
 // Shutdown gracefully shuts down the tracer provider.
 func (t *Tracer) Shutdown(ctx context.Context) error {
-	if t.provider == nil {
+	if t == nil || t.provider == nil {
 		return nil
 	}
 	return t.provider.Shutdown(ctx)
@@ -91,7 +101,7 @@ func (t *Tracer) Shutdown(ctx context.Context) error {
 func (t *Tracer) StartSpan(
 	ctx context.Context, name string, opts ...trace.SpanStartOption,
 ) (context.Context, trace.Span) {
-	if !t.enabled {
+	if t == nil || !t.enabled {
 		return ctx, trace.SpanFromContext(ctx)
 	}
 	return t.tracer.Start(ctx, name, opts...)
@@ -0,0 +1,6 @@
+-- Orders are listed newest first with (date_created, order_uid) as the keyset cursor.
+CREATE INDEX IF NOT EXISTS idx_orders_date_created ON orders(date_created DESC, order_uid DESC);
+
+-- Filters selective enough to be served by an index on their own, in listing order.
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders(customer_id, date_created DESC, order_uid DESC);
+CREATE INDEX IF NOT EXISTS idx_orders_delivery_service ON orders(delivery_service, date_created DESC, order_uid DESC);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_items_rid;
+DROP INDEX IF EXISTS idx_items_track_number;
+DROP INDEX IF EXISTS idx_orders_payment_transaction;
+DROP INDEX IF EXISTS idx_orders_track_number;
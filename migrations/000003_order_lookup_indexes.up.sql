@@ -0,0 +1,5 @@
+-- Lookups by identifiers support staff are given instead of an order UID.
+CREATE INDEX IF NOT EXISTS idx_orders_track_number ON orders(track_number);
+CREATE INDEX IF NOT EXISTS idx_orders_payment_transaction ON orders(payment_transaction);
+CREATE INDEX IF NOT EXISTS idx_items_track_number ON items(track_number);
+CREATE INDEX IF NOT EXISTS idx_items_rid ON items(rid);
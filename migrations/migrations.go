@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations of the order database so that
+// the binaries can apply and verify them without access to the source tree.
+package migrations
+
+import "embed"
+
+// FS holds the numbered NNNNNN_name.up.sql and NNNNNN_name.down.sql files.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
@@ -10,7 +11,10 @@ import (
 
 	"wildberries-tech/internal/cache"
 	"wildberries-tech/internal/handlers"
+	"wildberries-tech/internal/logging"
+	"wildberries-tech/internal/metrics"
 	"wildberries-tech/internal/models"
+	"wildberries-tech/internal/repository"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -22,12 +26,17 @@ type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) SaveOrder(order models.Order) error {
+func (m *MockRepository) SaveOrder(_ context.Context, order models.Order) error {
 	args := m.Called(order)
 	return args.Error(0)
 }
 
-func (m *MockRepository) GetOrder(orderUID string) (*models.Order, error) {
+func (m *MockRepository) SaveOrders(_ context.Context, orders []models.Order) error {
+	args := m.Called(orders)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetOrder(_ context.Context, orderUID string) (*models.Order, error) {
 	args := m.Called(orderUID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -35,8 +44,24 @@ func (m *MockRepository) GetOrder(orderUID string) (*models.Order, error) {
 	return args.Get(0).(*models.Order), args.Error(1)
 }
 
-func (m *MockRepository) GetAllOrders() ([]models.Order, error) {
-	args := m.Called()
+func (m *MockRepository) ListOrders(_ context.Context, filter repository.OrderFilter, after *repository.Cursor,
+	limit int) (repository.OrderPage, error) {
+	args := m.Called(filter, after, limit)
+	return args.Get(0).(repository.OrderPage), args.Error(1)
+}
+
+func (m *MockRepository) FindOrdersByTrackNumber(_ context.Context, trackNumber string) ([]models.Order, error) {
+	args := m.Called(trackNumber)
+	return args.Get(0).([]models.Order), args.Error(1)
+}
+
+func (m *MockRepository) FindOrdersByTransaction(_ context.Context, transaction string) ([]models.Order, error) {
+	args := m.Called(transaction)
+	return args.Get(0).([]models.Order), args.Error(1)
+}
+
+func (m *MockRepository) FindOrdersByRID(_ context.Context, rid string) ([]models.Order, error) {
+	args := m.Called(rid)
 	return args.Get(0).([]models.Order), args.Error(1)
 }
 
@@ -52,9 +77,9 @@ func TestIntegration_GetOrder(t *testing.T) {
 	// Setup
 	mockRepo := new(MockRepository)
 	// Use real cache
-	realCache := cache.New(5*time.Minute, 10*time.Minute)
+	realCache := cache.New(5*time.Minute, 10*time.Minute, metrics.Nop{})
 
-	h := handlers.New(mockRepo, realCache)
+	h := handlers.New(mockRepo, realCache, metrics.Nop{}, 0, logging.Nop())
 	router := mux.NewRouter()
 	router.HandleFunc("/order/{order_uid}", h.GetOrder)
 
@@ -80,7 +105,7 @@ func TestIntegration_GetOrder(t *testing.T) {
 	assert.Equal(t, orderUID, respOrder.OrderUID)
 
 	// Verify it's in cache now
-	cachedOrder, found := realCache.Get(orderUID)
+	cachedOrder, found := realCache.Get(context.Background(), orderUID)
 	assert.True(t, found)
 	assert.Equal(t, orderUID, cachedOrder.OrderUID)
 